@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"carrota-plugin-center/model"
+	"carrota-plugin-center/utils/logs"
+	"carrota-plugin-center/utils/metrics"
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 插件回复中需要执行的动作
+type pendingAction struct {
+	Plugin model.PluginInfo
+	Action model.PluginAction
+}
+
+// 依次执行插件动作，每个动作的结果单独记录，失败不影响其他动作
+func executeActions(ctx context.Context, origin model.MessageInfo, actions []pendingAction) {
+	for _, a := range actions {
+		err := executeAction(ctx, origin, a)
+		if err != nil {
+			logs.Warn("Execute plugin action failed", zap.String("id", a.Plugin.ID), zap.String("type", a.Action.Type), zap.Error(err))
+			metrics.Inc("plugin_actions_failed", a.Plugin.ID)
+			continue
+		}
+		logs.Info("Execute plugin action succeeded", zap.String("id", a.Plugin.ID), zap.String("type", a.Action.Type))
+		metrics.Inc("plugin_actions", a.Plugin.ID)
+	}
+}
+
+// 发送到指定会话时，有群号则发送到群聊，否则私聊发送给用户
+func targetReplyMode(target model.MessageInfo) string {
+	if target.GroupID != "" {
+		return model.ReplyModeGroup
+	}
+	return model.ReplyModePrivate
+}
+
+func executeAction(ctx context.Context, origin model.MessageInfo, a pendingAction) error {
+	action := a.Action
+	// 插件只能在启用了该插件的会话中执行动作
+	target := action.Target(origin)
+	if action.Type == model.ActionMention {
+		target = origin
+	}
+	enabled, err := model.IsPluginEnabledInScope(a.Plugin.ID, target.ChatScope())
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return fmt.Errorf("%w: %+v", model.ErrActionScope, target.ChatScope())
+	}
+
+	switch action.Type {
+	case model.ActionSend:
+		_, err := enqueueMessage(target, action.Message, targetReplyMode(target), a.Plugin.ID, time.Now())
+		return err
+
+	case model.ActionDelay:
+		// 写入 outbox 并在到期后投递，Plugin Center 重启不影响延迟发送
+		_, err := enqueueMessage(target, action.Message, targetReplyMode(target), a.Plugin.ID, time.Now().Add(time.Duration(action.Delay)*time.Second))
+		return err
+
+	case model.ActionRecall:
+		// 插件只能撤回以其名义发送的机器人消息，不能撤回用户或其他插件的消息
+		owned, err := model.IsPluginSentMessage(a.Plugin.ID, target.ChatScope(), action.MessageID)
+		if err != nil {
+			return err
+		}
+		if !owned {
+			return fmt.Errorf("%w: %s", model.ErrRecallNotOwned, action.MessageID)
+		}
+		return recallMessage(ctx, target, action.MessageID)
+
+	case model.ActionMention:
+		// @ 提及放在第一条消息的开头
+		mention := model.RichMessage{}
+		for _, userID := range action.UserIDs {
+			mention = append(mention, model.Segment{Type: model.SegmentMention, UserID: userID})
+		}
+		message := []model.RichMessage{mention}
+		if len(action.Message) > 0 {
+			message[0] = append(append(mention, model.Segment{Type: model.SegmentText, Text: " "}), action.Message[0]...)
+			message = append(message, action.Message[1:]...)
+		}
+		mode := a.Plugin.ReplyMode
+		if mode != model.ReplyModeGroup {
+			mode = model.ReplyModeReply
+		}
+		_, err := enqueueMessage(origin, message, mode, a.Plugin.ID, time.Now())
+		return err
+	}
+	return model.ErrInvalidAction
+}
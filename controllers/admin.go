@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"carrota-plugin-center/model"
+	"carrota-plugin-center/shared/service"
+	"carrota-plugin-center/utils/logs"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const adminMessageListMaxLimit = 100
+
+func AdminBreakersGET(c echo.Context) error {
+	logs.Debug("GET /admin/breakers")
+
+	return ResponseOK(c, service.Breakers())
+}
+
+func AdminMessagesGET(c echo.Context) error {
+	logs.Debug("GET /admin/messages")
+
+	status := c.QueryParam("status")
+	switch status {
+	case "", model.QueuedMessagePending, model.QueuedMessageProcessing, model.QueuedMessageDone, model.QueuedMessageFailed:
+	default:
+		return ResponseBadRequest(c, "Invalid status.", nil)
+	}
+	limit := 20
+	if l := c.QueryParam("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 || n > adminMessageListMaxLimit {
+			return ResponseBadRequest(c, "Invalid limit.", err)
+		}
+		limit = n
+	}
+
+	messages, err := model.FindQueuedMessages(status, limit)
+	if err != nil {
+		return ResponseInternalServerError(c, "Find queued messages failed.", err)
+	}
+	return ResponseOK(c, messages)
+}
+
+func AdminMessageGET(c echo.Context) error {
+	logs.Debug("GET /admin/messages/:id")
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return ResponseBadRequest(c, "Invalid id.", err)
+	}
+	message, err := model.FindQueuedMessageById(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ResponseNotFound(c, "Queued message not found.", err)
+	}
+	if err != nil {
+		return ResponseInternalServerError(c, "Find queued message failed.", err)
+	}
+	return ResponseOK(c, message)
+}
+
+func AdminWrapperPoliciesGET(c echo.Context) error {
+	logs.Debug("GET /admin/wrapper-policies")
+
+	rules, err := model.FindWrapperPolicyRules()
+	if err != nil {
+		return ResponseInternalServerError(c, "Find wrapper policy rules failed.", err)
+	}
+	return ResponseOK(c, rules)
+}
+
+func AdminWrapperPolicyPUT(c echo.Context) error {
+	logs.Debug("PUT /admin/wrapper-policies")
+
+	rule := model.WrapperPolicyRule{}
+	_ok, err := Bind(c, &rule)
+	if !_ok {
+		return err
+	}
+	rule.ID = 0
+	switch rule.ChatType {
+	case "", model.ChatTypeGroup, model.ChatTypePrivate:
+	default:
+		return ResponseBadRequest(c, "chat_type must be group, private or empty.", nil)
+	}
+	if rule.ChatID != "" && rule.ChatType == "" {
+		return ResponseBadRequest(c, "chat_type is required with chat_id.", nil)
+	}
+	if !model.IsValidWrapperPolicy(rule.Policy) {
+		return ResponseBadRequest(c, "policy must be one of "+strings.Join(model.WrapperPolicies, ", ")+".", nil)
+	}
+
+	rule, err = model.SetWrapperPolicyRule(rule)
+	if err != nil {
+		return ResponseInternalServerError(c, "Set wrapper policy rule failed.", err)
+	}
+	logs.Info("Wrapper policy rule set.", zap.Any("rule", rule))
+	return ResponseOK(c, rule)
+}
+
+func AdminWrapperPolicyDELETE(c echo.Context) error {
+	logs.Debug("DELETE /admin/wrapper-policies/:id")
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return ResponseBadRequest(c, "Invalid id.", err)
+	}
+	err = model.DeleteWrapperPolicyRule(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ResponseNotFound(c, "Wrapper policy rule not found.", err)
+	}
+	if err != nil {
+		return ResponseInternalServerError(c, "Delete wrapper policy rule failed.", err)
+	}
+	logs.Info("Wrapper policy rule deleted.", zap.Uint64("id", id))
+	return ResponseOK(c, "ok")
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"carrota-plugin-center/model"
+	"carrota-plugin-center/shared/service"
+	"carrota-plugin-center/utils/logs"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// 按名称查找 Agent。注册表中没有该 Agent 时，若配置了已弃用的 agent-endpoint 则使用该地址，否则返回 ErrUnknownAgent
+func resolveAgent(name string) (model.Agent, error) {
+	agent, err := model.FindAgentByName(name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if service.AgentEndpoint != "" {
+			return model.Agent{
+				Name:           name,
+				Endpoint:       service.AgentEndpoint,
+				RecallEndpoint: service.AgentRecallEndpoint,
+				Capabilities:   model.AgentCapabilities,
+			}, nil
+		}
+		return model.Agent{}, fmt.Errorf("%w: %q", model.ErrUnknownAgent, name)
+	}
+	return agent, err
+}
+
+// 请求 Agent 时携带的请求头
+func agentHeader(agent model.Agent) http.Header {
+	if agent.Token == "" {
+		return nil
+	}
+	return http.Header{"Authorization": {"Bearer " + agent.Token}}
+}
+
+// 校验请求中的 Agent 是否已注册，未注册时返回 400
+func checkAgent(c echo.Context, name string) (bool, error) {
+	_, err := resolveAgent(name)
+	if errors.Is(err, model.ErrUnknownAgent) {
+		return false, ResponseBadRequest(c, "Unknown agent.", err)
+	}
+	if err != nil {
+		return false, ResponseInternalServerError(c, "Find agent failed.", err)
+	}
+	return true, nil
+}
+
+func AdminAgentsGET(c echo.Context) error {
+	logs.Debug("GET /admin/agents")
+
+	agents, err := model.FindAgents()
+	if err != nil {
+		return ResponseInternalServerError(c, "Find agents failed.", err)
+	}
+	infos := make([]model.AgentInfo, 0, len(agents))
+	for _, agent := range agents {
+		infos = append(infos, agent.Info())
+	}
+	return ResponseOK(c, infos)
+}
+
+func AdminAgentGET(c echo.Context) error {
+	logs.Debug("GET /admin/agents/:name")
+
+	agent, err := model.FindAgentByName(c.Param("name"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ResponseNotFound(c, "Agent not found.", err)
+	}
+	if err != nil {
+		return ResponseInternalServerError(c, "Find agent failed.", err)
+	}
+	return ResponseOK(c, agent.Info())
+}
+
+func AdminAgentPUT(c echo.Context) error {
+	logs.Debug("PUT /admin/agents/:name")
+
+	info := model.AgentInfo{}
+	_ok, err := Bind(c, &info)
+	if !_ok {
+		return err
+	}
+	info.Name = c.Param("name")
+	err = info.Validate()
+	if validationErr, ok := err.(*model.ValidationError); ok {
+		return ResponseValidationFailed(c, "Invalid agent payload.", validationErr)
+	}
+
+	agent, err := model.SaveAgent(info)
+	if err != nil {
+		return ResponseInternalServerError(c, "Save agent failed.", err)
+	}
+	logs.Info("Agent saved.", zap.String("name", agent.Name), zap.String("endpoint", agent.Endpoint), zap.Strings("capabilities", agent.Capabilities))
+	return ResponseOK(c, agent.Info())
+}
+
+func AdminAgentDELETE(c echo.Context) error {
+	logs.Debug("DELETE /admin/agents/:name")
+
+	name := c.Param("name")
+	err := model.DeleteAgentByName(name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ResponseNotFound(c, "Agent not found.", err)
+	}
+	if err != nil {
+		return ResponseInternalServerError(c, "Delete agent failed.", err)
+	}
+	logs.Info("Agent deleted.", zap.String("name", name))
+	return ResponseOK(c, "ok")
+}
@@ -1,8 +1,10 @@
 package auth
 
 import (
+	"carrota-plugin-center/model"
 	"carrota-plugin-center/utils"
 	"carrota-plugin-center/utils/logs"
+	"crypto/subtle"
 	"errors"
 	"strings"
 	"time"
@@ -19,9 +21,13 @@ const (
 )
 
 var jwtAccessSecretKey string
+var adminToken string
+
+var ErrPluginTokenMismatch = errors.New("token does not belong to this plugin")
 
 type Authorization struct {
 	AccessSecretKey string `config:"secret-key"`
+	AdminToken      string `config:"admin-token"`
 }
 
 type Claims struct {
@@ -36,6 +42,10 @@ func InitAuthorization(a Authorization) error {
 		return errors.New("access-secret-key is empty")
 	}
 	jwtAccessSecretKey = a.AccessSecretKey
+	adminToken = a.AdminToken
+	if adminToken == "" {
+		logs.Warn("admin-token is empty, admin APIs are disabled.")
+	}
 	return nil
 }
 
@@ -94,3 +104,39 @@ func GetClaimsFromHeader(c echo.Context) (claims Claims, err error) {
 
 	return claims, nil
 }
+
+func getBearerToken(c echo.Context) string {
+	bearerToken := strings.Split(c.Request().Header.Get(tokenHeaderName), " ")
+	if len(bearerToken) < 2 || bearerToken[0] != "Bearer" {
+		return ""
+	}
+	return bearerToken[1]
+}
+
+func IsAdmin(c echo.Context) bool {
+	token := getBearerToken(c)
+	return adminToken != "" && token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// GeneratePluginToken 为插件签发凭证，tokenID 需与插件 ID 绑定保存，重置凭证时更换 tokenID 即可使旧凭证失效
+func GeneratePluginToken() (token string, tokenID string, expireAt time.Time, err error) {
+	tokenID = utils.RandSeq(UserIdLength)
+	token, expireAt, err = GenerateAccessToken(tokenID, false, 0, 0)
+	return token, tokenID, expireAt, err
+}
+
+// VerifyPluginOwnership 校验请求头中的凭证是否为该插件签发
+func VerifyPluginOwnership(c echo.Context, pluginID string) error {
+	claims, err := GetClaimsFromHeader(c)
+	if err != nil {
+		return err
+	}
+	credential, err := model.FindPluginCredential(pluginID)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.ID), []byte(credential.TokenID)) != 1 {
+		return ErrPluginTokenMismatch
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"carrota-plugin-center/model"
 	"carrota-plugin-center/utils/logs"
 	"net/http"
 
@@ -9,8 +10,9 @@ import (
 )
 
 type ErrorMessage struct {
-	Message string `json:"msg"`
-	Err     string `json:"err"`
+	Message string             `json:"msg"`
+	Err     string             `json:"err"`
+	Fields  []model.FieldError `json:"fields,omitempty"`
 }
 
 type StatusMessage struct {
@@ -63,6 +65,18 @@ func ResponseBadRequest(c echo.Context, errMessage string, err error) error {
 	})
 }
 
+func ResponseValidationFailed(c echo.Context, errMessage string, err *model.ValidationError) error {
+	return c.JSON(http.StatusBadRequest, ResponseStruct{
+		Code:    http.StatusBadRequest,
+		Message: "Bad Request",
+		Data: ErrorMessage{
+			Message: errMessage,
+			Err:     err.Error(),
+			Fields:  err.Fields,
+		},
+	})
+}
+
 func ResponseInternalServerError(c echo.Context, errMessage string, err error) error {
 	Err := ""
 	if err != nil {
@@ -108,6 +122,21 @@ func ResponseForbidden(c echo.Context, errMessage string, err error) error {
 	})
 }
 
+func ResponseNotFound(c echo.Context, errMessage string, err error) error {
+	Err := ""
+	if err != nil {
+		Err = err.Error()
+	}
+	return c.JSON(http.StatusNotFound, ResponseStruct{
+		Code:    http.StatusNotFound,
+		Message: "Not Found",
+		Data: ErrorMessage{
+			Message: errMessage,
+			Err:     Err,
+		},
+	})
+}
+
 func ResponseTooManyRequests(c echo.Context, errMessage string, err error) error {
 	Err := ""
 	if err != nil {
@@ -122,3 +151,33 @@ func ResponseTooManyRequests(c echo.Context, errMessage string, err error) error
 		},
 	})
 }
+
+func ResponseGatewayTimeout(c echo.Context, errMessage string, err error) error {
+	Err := ""
+	if err != nil {
+		Err = err.Error()
+	}
+	return c.JSON(http.StatusGatewayTimeout, ResponseStruct{
+		Code:    http.StatusGatewayTimeout,
+		Message: "Gateway Timeout",
+		Data: ErrorMessage{
+			Message: errMessage,
+			Err:     Err,
+		},
+	})
+}
+
+func ResponseConflict(c echo.Context, errMessage string, err error) error {
+	Err := ""
+	if err != nil {
+		Err = err.Error()
+	}
+	return c.JSON(http.StatusConflict, ResponseStruct{
+		Code:    http.StatusConflict,
+		Message: "Conflict",
+		Data: ErrorMessage{
+			Message: errMessage,
+			Err:     Err,
+		},
+	})
+}
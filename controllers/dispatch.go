@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"bytes"
+	"carrota-plugin-center/model"
+	"carrota-plugin-center/shared/service"
+	"carrota-plugin-center/utils"
+	"carrota-plugin-center/utils/logs"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 单个插件的上报结果
+type pluginResult struct {
+	Plugin model.PluginInfo
+	Reply  model.MessageReply
+	Err    error
+}
+
+// 插件的上报超时时间，未在注册时指定时使用全局配置
+func pluginTimeout(plugin model.PluginInfo) time.Duration {
+	if plugin.Timeout > 0 {
+		return time.Duration(plugin.Timeout) * time.Second
+	}
+	return service.PluginTimeout
+}
+
+// 插件的重试策略，注册时填写的字段覆盖全局配置
+func pluginRetryPolicy(plugin model.PluginInfo) service.RetryPolicy {
+	policy := service.PluginRetryPolicy
+	if plugin.Retry == nil {
+		return policy
+	}
+	if plugin.Retry.MaxAttempts > 0 {
+		policy.MaxAttempts = plugin.Retry.MaxAttempts
+	}
+	if plugin.Retry.InitialBackoff > 0 {
+		policy.InitialBackoff = time.Duration(plugin.Retry.InitialBackoff) * time.Millisecond
+	}
+	if plugin.Retry.MaxBackoff > 0 {
+		policy.MaxBackoff = time.Duration(plugin.Retry.MaxBackoff) * time.Millisecond
+	}
+	if policy.MaxBackoff < policy.InitialBackoff {
+		policy.MaxBackoff = policy.InitialBackoff
+	}
+	if len(plugin.Retry.RetryOnStatus) > 0 {
+		policy.RetryOnStatus = plugin.Retry.RetryOnStatus
+	}
+	return policy
+}
+
+// 下游请求成功，但响应无法解析
+var errInvalidResponse = errors.New("invalid response")
+
+// 按重试策略与熔断状态 POST JSON 到下游，header 为额外的请求头，out 不为 nil 时解析响应
+func postJSON(ctx context.Context, name string, url string, header http.Header, breaker *service.Breaker, policy service.RetryPolicy, body []byte, out interface{}) error {
+	resp, err := service.Do(ctx, name, breaker, policy, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidResponse, err)
+	}
+	return nil
+}
+
+// 在 ctx 的期限内按插件的重试策略上报插件
+func callPlugin(ctx context.Context, plugin model.PluginInfo, body []byte) (model.MessageReply, error) {
+	reply := model.MessageReply{}
+	err := postJSON(ctx, "Plugin endpoint", plugin.Url, nil, service.PluginBreaker(plugin.Url), pluginRetryPolicy(plugin), body, &reply)
+	return reply, err
+}
+
+// 并发上报插件，每个插件有独立的超时时间且不超过 ctx 的期限，结果按 plugins 的顺序返回
+func dispatchPlugins(ctx context.Context, message model.MessageInfo, plugins []model.PluginInfo, params []interface{}) []pluginResult {
+	results := make([]pluginResult, len(plugins))
+	wg := sync.WaitGroup{}
+	for i := range plugins {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			plugin := plugins[i]
+			results[i].Plugin = plugin
+
+			body, _ := json.Marshal(model.PostPluginRequest{
+				Agent:     message.Agent,
+				MessageID: message.MessageID,
+				GroupID:   message.GroupID,
+				GroupName: message.GroupName,
+				UserID:    message.UserID,
+				UserName:  message.UserName,
+				Time:      message.Time,
+				Message:   message.Message,
+				Segments:  message.Segments,
+				IsMention: message.IsMention,
+				History:   message.History,
+				Param:     params[i],
+			})
+
+			ctx, cancel := context.WithTimeout(ctx, pluginTimeout(plugin))
+			defer cancel()
+			start := time.Now()
+			reply, err := callPlugin(ctx, plugin, body)
+			if errors.Is(err, service.ErrCircuitOpen) {
+				// 熔断期间未实际上报，不计入插件的连续失败次数
+				results[i].Err = err
+				logs.Debug("Skip plugin with open circuit breaker", zap.String("id", plugin.ID), zap.String("url", plugin.Url))
+				return
+			}
+			if err != nil {
+				results[i].Err = err
+				logs.Warn("Deliver message to plugin failed", zap.String("id", plugin.ID), zap.String("name", plugin.Name), zap.Duration("elapsed", time.Since(start)), zap.Error(err))
+				// 超时同样视为上报失败
+				disabled, _ := model.RecordPluginDeliveryFailure(plugin.ID, utils.PluginMaxConsecutiveFailures)
+				if disabled {
+					logs.Warn("Plugin disabled after consecutive delivery failures", zap.String("id", plugin.ID), zap.String("name", plugin.Name), zap.Int("failures", utils.PluginMaxConsecutiveFailures))
+				}
+				return
+			}
+			model.RecordPluginDeliverySuccess(plugin.ID)
+			logs.Debug("pluginResponse", zap.String("name", plugin.Name), zap.Duration("elapsed", time.Since(start)), zap.Any("pluginResponse", reply))
+			results[i].Reply = reply
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"bytes"
+	"carrota-plugin-center/model"
+	"carrota-plugin-center/shared/service"
+	"carrota-plugin-center/utils/logs"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// 重复请求的响应会带有该响应头
+const idempotentReplayedHeader = "Idempotent-Replayed"
+
+// 记录响应内容，同时写回客户端
+type recordingWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// 处理中的请求占用 key 的时长，需大于同步处理与 /message/send 的期限
+func idempotencyLease() time.Duration {
+	return service.MessageSyncTimeout + 30*time.Second
+}
+
+// 以 (scope, key) 保证 next 在 service.IdempotencyRetention 内只执行一次，
+// 重复请求直接返回首次请求的响应。首次请求返回 5xx 或 panic 时不记录结果，允许客户端重试
+func withIdempotency(c echo.Context, scope string, key string, next func() error) error {
+	record, created, err := model.BeginIdempotentRequest(scope, key, idempotencyLease())
+	if err != nil {
+		return ResponseInternalServerError(c, "Check idempotency failed.", err)
+	}
+	if !created {
+		if record.StatusCode == 0 {
+			return ResponseConflict(c, "A request with the same key is still being processed.", nil)
+		}
+		logs.Info("Replay idempotent response", zap.String("scope", scope), zap.String("key", key))
+		c.Response().Header().Set(idempotentReplayedHeader, "true")
+		return c.Blob(record.StatusCode, record.ContentType, []byte(record.Body))
+	}
+
+	writer := &recordingWriter{ResponseWriter: c.Response().Writer}
+	c.Response().Writer = writer
+	finished := false
+	defer func() {
+		c.Response().Writer = writer.ResponseWriter
+		if !finished {
+			model.AbortIdempotentRequest(scope, key)
+		}
+	}()
+	err = next()
+
+	status := c.Response().Status
+	if err != nil || status >= http.StatusInternalServerError {
+		return err
+	}
+	err = model.FinishIdempotentRequest(scope, key, status, c.Response().Header().Get(echo.HeaderContentType), writer.body.String(), service.IdempotencyRetention)
+	if err != nil {
+		// 响应已经返回，释放 key 使重复请求可以重新处理，而不是在租约内一直返回 409
+		logs.Error("Save idempotent response failed", zap.String("scope", scope), zap.String("key", key), zap.Error(err))
+		return nil
+	}
+	finished = true
+	return nil
+}
@@ -1,21 +1,30 @@
 package controllers
 
 import (
-	"bytes"
+	"carrota-plugin-center/controllers/auth"
 	"carrota-plugin-center/model"
+	outboxDispatcher "carrota-plugin-center/shared/outbox"
+	"carrota-plugin-center/shared/queue"
 	"carrota-plugin-center/shared/service"
-	"carrota-plugin-center/utils"
 	"carrota-plugin-center/utils/logs"
+	"carrota-plugin-center/utils/metrics"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
-func wrapAndSendMessage(originMessage model.MessageInfo, message []string) error {
-	// 提交 Wrapper
+// 提交 Wrapper，返回包装后的回复。Wrapper 只处理纯文本，未返回 segments 时
+// 原回复中的引用与 @ 提及放在包装后第一条消息的开头，图片与文件附加在包装后的文字之后
+func wrapMessage(ctx context.Context, originMessage model.MessageInfo, message []model.RichMessage) ([]model.RichMessage, error) {
 	wrapperRequest := model.PostWrapperRequest{
 		Agent:            originMessage.Agent,
 		GroupID:          originMessage.GroupID,
@@ -24,142 +33,508 @@ func wrapAndSendMessage(originMessage model.MessageInfo, message []string) error
 		UserName:         originMessage.UserName,
 		Time:             time.Now().Unix(),
 		Message:          originMessage.Message,
-		OriginalResponse: message,
+		Segments:         originMessage.Segments,
+		OriginalResponse: model.PlainTexts(message),
+		OriginalSegments: message,
 	}
 	jsonStr, _ := json.Marshal(wrapperRequest)
-	req, _ := http.NewRequest("POST", service.WrapperEndpoint, bytes.NewBuffer(jsonStr))
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil || resp.StatusCode != 200 {
-		if resp == nil {
-			logs.Error("POST Wrapper endpoint failed", zap.Error(err))
-		} else {
-			logs.Error("POST Wrapper endpoint failed", zap.Int("statusCode", resp.StatusCode), zap.Error(err))
+	wrapperResponse := model.PostWrapperResponse{}
+	err := postJSON(ctx, "Wrapper endpoint", service.WrapperEndpoint, nil, service.WrapperBreaker(), service.WrapperRetryPolicy, jsonStr, &wrapperResponse)
+	if err != nil {
+		logs.Error("POST Wrapper endpoint failed", zap.Error(err))
+		return nil, err
+	}
+	logs.Debug("wrapperResponse", zap.Any("wrapperResponse", wrapperResponse))
+	if len(wrapperResponse.Segments) > 0 {
+		return wrapperResponse.Segments, nil
+	}
+
+	wrapped := model.TextMessages(wrapperResponse.Response)
+	references := model.RichMessage{}
+	quoted, mentioned := false, map[string]bool{}
+	for _, m := range message {
+		for _, segment := range m.References() {
+			// 一条消息只能引用一条消息，同一用户只 @ 一次
+			if segment.Type == model.SegmentQuote {
+				if quoted {
+					continue
+				}
+				quoted = true
+				references = append(model.RichMessage{segment}, references...)
+				continue
+			}
+			if mentioned[segment.UserID] {
+				continue
+			}
+			mentioned[segment.UserID] = true
+			references = append(references, segment)
+		}
+	}
+	if len(references) > 0 {
+		if len(wrapped) == 0 {
+			wrapped = []model.RichMessage{{}}
+		}
+		if len(wrapped[0]) > 0 {
+			references = append(references, model.Segment{Type: model.SegmentText, Text: " "})
+		}
+		wrapped[0] = append(references, wrapped[0]...)
+	}
+	for _, m := range message {
+		if attachments := m.Attachments(); len(attachments) > 0 {
+			wrapped = append(wrapped, attachments)
 		}
-		return err
 	}
+	return wrapped, nil
+}
 
-	wrapperResponse := model.PostWrapperResponse{}
-	err = json.NewDecoder(resp.Body).Decode(&wrapperResponse)
-	logs.Debug("wrapperResponse", zap.Any("wrapperResponse", wrapperResponse))
+// 检查目标 Agent 能否发送该消息
+func checkAgentCapability(agent model.Agent, replyMode string) error {
+	if replyMode == model.ReplyModePrivate && !agent.Can(model.AgentCapabilityPrivate) {
+		return fmt.Errorf("%w: %q cannot send private messages", model.ErrAgentCapability, agent.Name)
+	}
+	return nil
+}
+
+// 构造发送到 Agent 的 outbox 消息，在 sendAt 之后投递，replyMode 为空时按引用原消息回复处理。
+// Agent 未注册或不支持该消息时返回错误
+func newOutboxMessage(target model.MessageInfo, message []model.RichMessage, replyMode string, pluginID string, sendAt time.Time) (model.OutboxMessage, error) {
+	agent, err := resolveAgent(target.Agent)
 	if err != nil {
-		logs.Error("Decode wrapperResponse failed", zap.Error(err))
-		return err
+		logs.Warn("Resolve agent failed", zap.String("agent", target.Agent), zap.Error(err))
+		return model.OutboxMessage{}, err
+	}
+	err = checkAgentCapability(agent, replyMode)
+	if err != nil {
+		return model.OutboxMessage{}, err
 	}
-	resp.Body.Close()
 
-	// 提交 Agent 发送信息
-	jsonStr, _ = json.Marshal(model.MessageSendRequest{
-		Agent:     originMessage.Agent,
-		MessageID: originMessage.MessageID,
-		GroupID:   originMessage.GroupID,
-		UserID:    originMessage.UserID,
-		Message:   wrapperResponse.Response,
+	// 同时清空对应字段，使不识别 reply_mode 的 Agent 也能按预期发送
+	switch replyMode {
+	case model.ReplyModeGroup:
+		target.MessageID = ""
+	case model.ReplyModePrivate:
+		target.GroupID = ""
+	}
+	return model.NewOutboxMessage(target, message, replyMode, pluginID, sendAt), nil
+}
+
+// 将发送到 Agent 的消息写入 outbox，由 dispatcher 在 sendAt 之后投递
+func enqueueMessage(target model.MessageInfo, message []model.RichMessage, replyMode string, pluginID string, sendAt time.Time) (model.OutboxMessage, error) {
+	outbox, err := newOutboxMessage(target, message, replyMode, pluginID, sendAt)
+	if err != nil {
+		return outbox, err
+	}
+	outbox, err = model.CreateOutboxMessage(outbox)
+	if err != nil {
+		return outbox, err
+	}
+	outboxDispatcher.Notify()
+	return outbox, nil
+}
+
+// DeliverOutboxMessage 将 outbox 中的消息提交给 Agent，返回 Agent 的响应，由 outbox 的 dispatcher 调用
+func DeliverOutboxMessage(outbox model.OutboxMessage) (string, error) {
+	agent, err := resolveAgent(outbox.Agent)
+	if err != nil {
+		return "", err
+	}
+	err = checkAgentCapability(agent, outbox.ReplyMode)
+	if err != nil {
+		return "", err
+	}
+	message := []model.RichMessage(outbox.Message)
+	// 不支持富文本的 Agent 只接收纯文本
+	if !agent.Can(model.AgentCapabilitySegments) {
+		message = model.TextMessages(model.PlainTexts(message))
+	}
+
+	jsonStr, _ := json.Marshal(model.MessageSendRequest{
+		Agent:     outbox.Agent,
+		MessageID: outbox.MessageID,
+		GroupID:   outbox.GroupID,
+		UserID:    outbox.UserID,
+		Message:   message,
+		ReplyMode: outbox.ReplyMode,
 	})
-	req, _ = http.NewRequest("POST", service.AgentEndpoint, bytes.NewBuffer(jsonStr))
-	req.Header.Set("Content-Type", "application/json")
-	client = &http.Client{}
-	resp, err = client.Do(req)
-	if err != nil || resp.StatusCode != 200 {
-		if resp == nil {
-			logs.Error("POST Agent endpoint failed", zap.Error(err))
-		} else {
-			logs.Error("POST Agent endpoint failed", zap.Int("statusCode", resp.StatusCode), zap.Error(err))
-		}
+	// 同一条消息重新投递时 Idempotency-Key 不变，Agent 可以据此去重
+	header := agentHeader(agent)
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Idempotency-Key", "outbox-"+strconv.FormatUint(uint64(outbox.ID), 10))
+
+	// Agent 没有响应时不能一直占用 dispatcher 的 worker
+	ctx, cancel := context.WithTimeout(context.Background(), service.OutboxTimeout)
+	defer cancel()
+	raw := json.RawMessage{}
+	err = postJSON(ctx, "Agent endpoint", agent.Endpoint, header, service.AgentBreaker(agent.Name), service.AgentRetryPolicy, jsonStr, &raw)
+	// Agent 可以不返回消息 ID
+	if err != nil && !errors.Is(err, errInvalidResponse) {
+		return "", err
+	}
+	agentResponse := model.AgentSendResponse{}
+	json.Unmarshal(raw, &agentResponse)
+	recordBotReplies(model.NewChatScope(outbox.Agent, outbox.GroupID, outbox.UserID), message, agentResponse.MessageID)
+	return string(raw), nil
+}
+
+// 请求 Agent 撤回机器人发送过的消息
+func recallMessage(ctx context.Context, target model.MessageInfo, messageID string) error {
+	agent, err := resolveAgent(target.Agent)
+	if err != nil {
+		logs.Warn("Resolve agent failed", zap.String("agent", target.Agent), zap.Error(err))
 		return err
 	}
+	if !agent.Can(model.AgentCapabilityRecall) {
+		return fmt.Errorf("%w: %q cannot recall messages", model.ErrAgentCapability, agent.Name)
+	}
 
+	jsonStr, _ := json.Marshal(model.AgentRecallRequest{
+		Agent:     target.Agent,
+		MessageID: messageID,
+		GroupID:   target.GroupID,
+		UserID:    target.UserID,
+	})
+	err = postJSON(ctx, "Agent recall endpoint", agent.RecallUrl(), agentHeader(agent), service.AgentBreaker(agent.Name), service.AgentRetryPolicy, jsonStr, nil)
+	if err != nil {
+		logs.Error("POST Agent recall endpoint failed", zap.String("agent", agent.Name), zap.Error(err))
+		return err
+	}
 	return nil
 }
 
-func processUserMessage(message model.MessageInfo) error {
-	// 提交 Parser
-	jsonStr, _ := json.Marshal(message)
-	req, _ := http.NewRequest("POST", service.ParserEndpoint, bytes.NewBuffer(jsonStr))
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil || resp.StatusCode != 200 {
-		if resp == nil {
-			logs.Error("POST Parser endpoint failed", zap.Error(err))
-		} else {
-			logs.Error("POST Parser endpoint failed", zap.Int("statusCode", resp.StatusCode), zap.Error(err))
+// 回复的 Wrapper 策略：插件或会话设置为 never 时不包装，否则插件的设置优先于会话的设置，均未设置时使用全局配置
+func resolveWrapperPolicy(pluginPolicy string, chatPolicy string) string {
+	if pluginPolicy == model.WrapperPolicyNever || chatPolicy == model.WrapperPolicyNever {
+		return model.WrapperPolicyNever
+	}
+	if pluginPolicy != "" {
+		return pluginPolicy
+	}
+	if chatPolicy != "" {
+		return chatPolicy
+	}
+	return service.WrapperPolicy
+}
+
+// 会话设置的 Wrapper 策略，查询失败时视为未设置
+func chatWrapperPolicy(scope model.ChatScope) string {
+	policy, err := model.FindChatWrapperPolicy(scope)
+	if err != nil {
+		logs.Warn("Find chat wrapper policy failed", zap.Any("scope", scope), zap.Error(err))
+	}
+	return policy
+}
+
+// 按 Wrapper 策略包装回复，未经包装发送的回复会记录在日志与 /metrics 的 unwrapped_replies 计数中
+func wrapMessageWithPolicy(ctx context.Context, originMessage model.MessageInfo, message []model.RichMessage, policy string) ([]model.RichMessage, error) {
+	if policy == model.WrapperPolicyNever {
+		logs.Info("Deliver reply without Wrapper", zap.String("message_id", originMessage.MessageID), zap.String("policy", policy), zap.Int("count", len(message)))
+		metrics.Inc("unwrapped_replies", policy)
+		return message, nil
+	}
+	wrapped, err := wrapMessage(ctx, originMessage, message)
+	if err != nil && policy == model.WrapperPolicyFallback {
+		logs.Warn("Wrapper failed, deliver original reply without Wrapper", zap.String("message_id", originMessage.MessageID), zap.String("policy", policy), zap.Int("count", len(message)), zap.Error(err))
+		metrics.Inc("unwrapped_replies", policy)
+		return message, nil
+	}
+	return wrapped, err
+}
+
+func wrapAndSendMessage(ctx context.Context, originMessage model.MessageInfo, message []model.RichMessage, replyMode string, wrapperPolicy string, pluginID string) (model.OutboxMessage, error) {
+	wrapped, err := wrapMessageWithPolicy(ctx, originMessage, message, wrapperPolicy)
+	if err != nil {
+		return model.OutboxMessage{}, err
+	}
+	return enqueueMessage(originMessage, wrapped, replyMode, pluginID, time.Now())
+}
+
+// 由 param 生成的 schema 没有声明参数是否必需，Parser 对可选参数返回的 null 视为未提供
+func dropNullParams(param interface{}) interface{} {
+	object, ok := param.(map[string]interface{})
+	if !ok {
+		return param
+	}
+	dropped := make(map[string]interface{}, len(object))
+	for key, value := range object {
+		if value != nil {
+			dropped[key] = value
 		}
-		return err
 	}
+	return dropped
+}
 
-	parserResponse := model.ParserResponse{}
-	err = json.NewDecoder(resp.Body).Decode(&parserResponse)
+// 按插件参数 Schema 转换并校验 Parser 返回的参数，不合法的参数不会上报给插件
+func checkPluginParam(plugin model.PluginInfo, param interface{}) (interface{}, bool) {
+	s, err := plugin.ParamSchema()
 	if err != nil {
-		logs.Error("Decode parserResponse failed", zap.Error(err))
-		return err
+		logs.Error("Parse plugin param schema failed", zap.String("id", plugin.ID), zap.Error(err))
+		metrics.Inc("plugin_param_schema_errors", plugin.ID)
+		return param, false
+	}
+	if s == nil {
+		return param, true
+	}
+
+	if len(plugin.Schema) == 0 {
+		param = dropNullParams(param)
+	}
+	coerced := s.Coerce(param)
+	violations := s.Validate(coerced)
+	if len(violations) > 0 {
+		logs.Warn("Parser param violates plugin schema", zap.String("id", plugin.ID), zap.Any("param", param), zap.Any("violations", violations))
+		metrics.Inc("plugin_param_violations", plugin.ID)
+		return coerced, false
 	}
-	logs.Debug("parserResponse", zap.Any("parserResponse", parserResponse))
-	resp.Body.Close()
+	return coerced, true
+}
+
+// 读取会话最近的历史消息，并将当前消息记入会话历史
+func attachHistory(message model.MessageInfo) []model.HistoryMessage {
+	if service.ContextTurns <= 0 {
+		return nil
+	}
+	scope := message.ChatScope()
+	history, err := model.FindConversationHistory(scope, service.ContextTurns)
+	if err != nil {
+		logs.Warn("Find conversation history failed", zap.Any("scope", scope), zap.Error(err))
+	}
+	// 消息重新处理（同步请求失败后重试、队列重启后继续处理）时，历史中已经包含当前消息
+	if message.MessageID != "" {
+		filtered := history[:0]
+		for _, h := range history {
+			if h.Role != model.ConversationRoleUser || h.MessageID != message.MessageID {
+				filtered = append(filtered, h)
+			}
+		}
+		history = filtered
+	}
+
+	t := message.Time
+	if t == 0 {
+		t = time.Now().Unix()
+	}
+	model.RecordConversationMessages(scope, []model.HistoryMessage{{
+		Role:      model.ConversationRoleUser,
+		MessageID: message.MessageID,
+		UserID:    message.UserID,
+		UserName:  message.UserName,
+		Message:   message.Message,
+		Time:      t,
+	}}, service.ContextTurns)
+	return history
+}
 
-	// 提交 Plugin
-	messageReply := model.MessageReply{}
+// 将机器人发送到会话中的消息以纯文本形式记入会话历史，messageIDs 为 Agent 返回的对应消息 ID
+func recordBotReplies(scope model.ChatScope, message []model.RichMessage, messageIDs []string) {
+	if service.ContextTurns <= 0 || len(message) == 0 {
+		return
+	}
+	now := time.Now().Unix()
+	history := make([]model.HistoryMessage, 0, len(message))
+	for i, m := range message {
+		h := model.HistoryMessage{
+			Role:    model.ConversationRoleBot,
+			Message: m.PlainText(),
+			Time:    now,
+		}
+		if len(messageIDs) == len(message) {
+			h.MessageID = messageIDs[i]
+		}
+		history = append(history, h)
+	}
+	model.RecordConversationMessages(scope, history, service.ContextTurns)
+}
+
+// 插件回复的回复方式与 Wrapper 策略
+type replyKey struct {
+	Mode          string
+	WrapperPolicy string
+}
+
+// 按回复方式与 Wrapper 策略汇总的插件回复
+type pendingReply struct {
+	replyKey
+	Message  []model.RichMessage
+	PluginID string // 回复只来自一个插件时以该插件名义发送，之后该插件可以撤回
+}
+
+// 提交 Parser 链并上报选中的插件，返回按回复方式与 Wrapper 策略汇总、未经 Wrapper 包装的回复，以及插件要求执行的其他动作。
+// 只有 is_reply 为 true 的插件回复与 reply 动作会被汇总，silent 插件的回复与动作会被丢弃
+func collectPluginReplies(ctx context.Context, message model.MessageInfo) ([]pendingReply, []pendingAction, error) {
+	message.History = attachHistory(message)
+
+	// 提交 Parser 链
+	parserResponse, err := parseMessage(ctx, message)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 筛选需要上报的插件
+	plugins := []model.PluginInfo{}
+	params := []interface{}{}
 	for _, parserPlugin := range parserResponse.Plugin {
 		plugin, err := model.FindPluginById(parserPlugin.ID)
 		if err != nil {
 			continue
 		}
+		if plugin.Status == model.PluginStatusDisabled {
+			logs.Debug("Skip disabled plugin", zap.String("id", plugin.ID), zap.String("name", plugin.Name))
+			continue
+		}
+		enabled, err := model.IsPluginEnabledInScope(plugin.ID, message.ChatScope())
+		if err != nil || !enabled {
+			logs.Debug("Skip plugin not enabled in this chat", zap.String("id", plugin.ID), zap.Any("scope", message.ChatScope()), zap.Error(err))
+			continue
+		}
 
-		pluginStr, _ := json.Marshal(model.PostPluginRequest{
-			Agent:     message.Agent,
-			MessageID: message.MessageID,
-			GroupID:   message.GroupID,
-			GroupName: message.GroupName,
-			UserID:    message.UserID,
-			UserName:  message.UserName,
-			Time:      message.Time,
-			Message:   message.Message,
-			IsMention: message.IsMention,
-			Param:     parserPlugin.Param,
-		})
-		var resp *http.Response
-		for i := 0; i < utils.FailedAttempts; i++ {
-			req, _ := http.NewRequest("POST", plugin.Url, bytes.NewBuffer(pluginStr))
-			req.Header.Set("Content-Type", "application/json")
-			client := &http.Client{}
-			resp, err = client.Do(req)
-			if err == nil && resp.StatusCode == 200 {
-				break
+		param, ok := checkPluginParam(plugin, parserPlugin.Param)
+		if !ok {
+			continue
+		}
+		plugins = append(plugins, plugin)
+		params = append(params, param)
+	}
+
+	// 并发提交 Plugin，同一回复方式与 Wrapper 策略内按 Parser 返回的顺序汇总回复
+	chatPolicy := chatWrapperPolicy(message.ChatScope())
+	replies := map[replyKey][]model.RichMessage{}
+	repliedBy := map[replyKey][]string{}
+	actions := []pendingAction{}
+	for _, result := range dispatchPlugins(ctx, message, plugins, params) {
+		if result.Err != nil {
+			continue
+		}
+		mode := result.Plugin.ReplyMode
+		if mode == "" {
+			mode = model.ReplyModeReply
+		}
+		// silent 插件不会向任何会话发送消息，也不执行动作
+		if mode == model.ReplyModeSilent {
+			if result.Reply.IsReply || len(result.Reply.Actions) > 0 {
+				logs.Debug("Drop reply and actions of silent plugin", zap.String("id", result.Plugin.ID))
+			}
+			continue
+		}
+		reply := []model.RichMessage{}
+		if result.Reply.IsReply {
+			reply = append(reply, result.Reply.Message...)
+		}
+		for _, action := range result.Reply.Actions {
+			err := action.Validate()
+			if err != nil {
+				logs.Warn("Skip invalid plugin action", zap.String("id", result.Plugin.ID), zap.Any("action", action), zap.Error(err))
+				metrics.Inc("plugin_actions_failed", result.Plugin.ID)
+				continue
 			}
-			if resp == nil {
-				logs.Warn("POST Plugin endpoint failed", zap.String("name", plugin.Name), zap.String("url", plugin.Url), zap.Error(err))
-			} else {
-				logs.Warn("POST Plugin endpoint failed", zap.String("name", plugin.Name), zap.String("url", plugin.Url), zap.Int("statusCode", resp.StatusCode), zap.Error(err))
+			if action.Type == model.ActionReply {
+				reply = append(reply, action.Message...)
+				continue
 			}
+			actions = append(actions, pendingAction{Plugin: result.Plugin, Action: action})
 		}
-
-		if err != nil || resp.StatusCode != 200 {
-			// model.DeletePluginById(plugin.ID)
+		if len(reply) == 0 {
 			continue
 		}
+		key := replyKey{Mode: mode, WrapperPolicy: resolveWrapperPolicy(result.Plugin.WrapperPolicy, chatPolicy)}
+		replies[key] = append(replies[key], reply...)
+		repliedBy[key] = append(repliedBy[key], result.Plugin.ID)
+	}
 
-		pluginResponse := model.MessageReply{}
-		err = json.NewDecoder(resp.Body).Decode(&pluginResponse)
-		if err != nil {
-			logs.Error("Decode pluginResponse failed", zap.Error(err))
-			return err
+	pending := []pendingReply{}
+	for _, mode := range model.ReplyModes {
+		for _, policy := range model.WrapperPolicies {
+			key := replyKey{Mode: mode, WrapperPolicy: policy}
+			if len(replies[key]) == 0 {
+				continue
+			}
+			reply := pendingReply{replyKey: key, Message: replies[key]}
+			if len(repliedBy[key]) == 1 {
+				reply.PluginID = repliedBy[key][0]
+			}
+			pending = append(pending, reply)
 		}
-		resp.Body.Close()
-		logs.Debug("pluginResponse", zap.String("name", plugin.Name), zap.Any("pluginResponse", pluginResponse))
+	}
+	return pending, actions, nil
+}
 
-		messageReply.IsReply = messageReply.IsReply || pluginResponse.IsReply
-		messageReply.Message = append(messageReply.Message, pluginResponse.Message...)
+// ProcessUserMessage 依次提交 Parser、插件、Wrapper 与 Agent，由消息队列的 worker 调用
+func ProcessUserMessage(message model.MessageInfo) error {
+	// 下游请求没有响应时不能一直占用 worker
+	ctx, cancel := context.WithTimeout(context.Background(), service.MessageProcessTimeout)
+	defer cancel()
+
+	pending, actions, err := collectPluginReplies(ctx, message)
+	if err != nil {
+		return err
+	}
+	executeActions(ctx, message, actions)
+	if len(pending) == 0 {
+		logs.Debug("No plugin replied", zap.String("message_id", message.MessageID))
+		return nil
 	}
-	if messageReply.IsReply || true {
-		err = wrapAndSendMessage(message, messageReply.Message)
+	// 某一组回复发送失败时仍发送其他组，失败原因一并记录到消息队列
+	failed := []string{}
+	for _, reply := range pending {
+		_, err = wrapAndSendMessage(ctx, message, reply.Message, reply.Mode, reply.WrapperPolicy, reply.PluginID)
 		if err != nil {
-			return err
+			logs.Warn("Send reply failed", zap.String("message_id", message.MessageID), zap.String("reply_mode", reply.Mode), zap.String("wrapper_policy", reply.WrapperPolicy), zap.Error(err))
+			failed = append(failed, fmt.Sprintf("%s (%s): %v", reply.Mode, reply.WrapperPolicy, err))
 		}
 	}
+	if len(failed) > 0 {
+		return fmt.Errorf("send %d of %d replies failed: %s", len(failed), len(pending), strings.Join(failed, "; "))
+	}
 	return nil
 }
 
+// 同步处理消息，在 ctx 的期限内返回 Wrapper 包装后的回复。
+// reply 与 group 方式的回复在响应中返回，private 方式的回复仍通过 Agent 私聊发送
+func processUserMessageSync(ctx context.Context, message model.MessageInfo) (model.MessageReply, error) {
+	messageReply := model.MessageReply{Message: []model.RichMessage{}}
+	pending, actions, err := collectPluginReplies(ctx, message)
+	if err != nil {
+		return messageReply, err
+	}
+	executeActions(ctx, message, actions)
+
+	inline := []model.RichMessage{}
+	for _, reply := range pending {
+		wrapped, err := wrapMessageWithPolicy(ctx, message, reply.Message, reply.WrapperPolicy)
+		if reply.Mode == model.ReplyModePrivate {
+			if err == nil {
+				_, err = enqueueMessage(message, wrapped, reply.Mode, reply.PluginID, time.Now())
+			}
+			if err != nil {
+				logs.Warn("Send private reply failed", zap.String("message_id", message.MessageID), zap.Error(err))
+			}
+			continue
+		}
+		if err != nil {
+			return messageReply, err
+		}
+		inline = append(inline, wrapped...)
+	}
+	if len(inline) == 0 {
+		return messageReply, nil
+	}
+
+	recordBotReplies(message.ChatScope(), inline, nil)
+	messageReply.IsReply = true
+	messageReply.Message = inline
+	return messageReply, nil
+}
+
+// 请求是否要求同步返回回复，通过 ?sync=true 或请求头 X-Carrota-Sync: true 开启
+func isSyncRequest(c echo.Context) bool {
+	if sync, err := strconv.ParseBool(c.QueryParam("sync")); err == nil && sync {
+		return true
+	}
+	sync, err := strconv.ParseBool(c.Request().Header.Get("X-Carrota-Sync"))
+	return err == nil && sync
+}
+
 func MessagePOST(c echo.Context) error {
 	logs.Debug("POST /message")
 
@@ -168,8 +543,44 @@ func MessagePOST(c echo.Context) error {
 	if !_ok {
 		return err
 	}
+	err = message.NormalizeSegments()
+	if err != nil {
+		return ResponseBadRequest(c, "Invalid message segments.", err)
+	}
+	_ok, err = checkAgent(c, message.Agent)
+	if !_ok {
+		return err
+	}
 
-	go processUserMessage(message)
+	// Agent 重试上报同一条消息时直接返回首次的结果
+	if message.Agent != "" && message.MessageID != "" {
+		return withIdempotency(c, model.IdempotencyScopeMessage, message.Agent+":"+message.MessageID, func() error {
+			return acceptUserMessage(c, message)
+		})
+	}
+	return acceptUserMessage(c, message)
+}
+
+func acceptUserMessage(c echo.Context, message model.MessageInfo) error {
+	if isSyncRequest(c) {
+		ctx, cancel := context.WithTimeout(c.Request().Context(), service.MessageSyncTimeout)
+		defer cancel()
+		reply, err := processUserMessageSync(ctx, message)
+		if ctx.Err() == context.DeadlineExceeded {
+			return ResponseGatewayTimeout(c, "Process message timed out.", ctx.Err())
+		}
+		if err != nil {
+			return ResponseInternalServerError(c, "Process message failed.", err)
+		}
+		return ResponseOK(c, reply)
+	}
+
+	// 先持久化到队列再返回，由 worker 异步处理
+	_, err := model.CreateQueuedMessage(message)
+	if err != nil {
+		return ResponseInternalServerError(c, "Enqueue message failed", err)
+	}
+	queue.Notify()
 
 	return ResponseOK(c, "ok")
 }
@@ -183,15 +594,105 @@ func MessageSendPOST(c echo.Context) error {
 		return err
 	}
 
-	err = wrapAndSendMessage(model.MessageInfo{
+	// 以插件名义发送的消息需携带该插件的凭证
+	if message.PluginID != "" && !auth.IsAdmin(c) {
+		err = auth.VerifyPluginOwnership(c, message.PluginID)
+		if err != nil {
+			return ResponseUnauthorized(c, "A valid token of this plugin is required to send as it.", err)
+		}
+	}
+
+	if message.ReplyMode != "" && !model.IsValidReplyMode(message.ReplyMode) {
+		return ResponseBadRequest(c, "Invalid reply_mode.", nil)
+	}
+	_ok, err = checkAgent(c, message.Agent)
+	if !_ok {
+		return err
+	}
+	if message.ReplyMode == model.ReplyModeSilent {
+		return ResponseOK(c, "ok")
+	}
+
+	// 携带 Idempotency-Key 的重复请求直接返回首次的结果。key 按调用方的身份区分，
+	// 没有身份的调用方无法避免与他人的 key 冲突，不能使用 Idempotency-Key
+	if key := c.Request().Header.Get("Idempotency-Key"); key != "" {
+		switch {
+		case message.PluginID != "":
+			key = message.PluginID + ":" + key
+		case auth.IsAdmin(c):
+			key = "@admin:" + key
+		default:
+			return ResponseBadRequest(c, "Idempotency-Key requires plugin_id or the admin token.", nil)
+		}
+		return withIdempotency(c, model.IdempotencyScopeSend, key, func() error {
+			return sendUserMessage(c, message)
+		})
+	}
+	return sendUserMessage(c, message)
+}
+
+// 以插件（pluginID 为空时以 center）的名义发送到会话的消息使用的 Wrapper 策略
+func sendWrapperPolicy(scope model.ChatScope, pluginID string) string {
+	pluginPolicy := ""
+	if pluginID != "" {
+		plugin, err := model.FindPluginById(pluginID)
+		if err == nil {
+			pluginPolicy = plugin.WrapperPolicy
+		}
+	}
+	return resolveWrapperPolicy(pluginPolicy, chatWrapperPolicy(scope))
+}
+
+// 以插件（pluginID 为空时以 center）的名义发送消息，按插件与会话的 Wrapper 策略包装后写入 outbox
+func sendMessage(ctx context.Context, origin model.MessageInfo, message []model.RichMessage, replyMode string, pluginID string) (model.OutboxMessage, error) {
+	policy := sendWrapperPolicy(origin.ChatScope(), pluginID)
+	return wrapAndSendMessage(ctx, origin, message, replyMode, policy, pluginID)
+}
+
+func sendUserMessage(c echo.Context, message model.MessageSendRequest) error {
+	origin := model.MessageInfo{
 		MessageID: message.MessageID,
 		Agent:     message.Agent,
 		GroupID:   message.GroupID,
 		UserID:    message.UserID,
-	}, message.Message)
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), service.MessageSyncTimeout)
+	defer cancel()
+	outbox, err := sendMessage(ctx, origin, message.Message, message.ReplyMode, message.PluginID)
+	if errors.Is(err, model.ErrAgentCapability) {
+		return ResponseBadRequest(c, "The agent does not support this message.", err)
+	}
 	if err != nil {
 		return ResponseInternalServerError(c, "Send message failed", err)
 	}
 
-	return ResponseOK(c, "ok")
+	return ResponseOK(c, outbox)
+}
+
+func MessageSendGET(c echo.Context) error {
+	logs.Debug("GET /message/send/:id")
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return ResponseBadRequest(c, "Invalid id.", err)
+	}
+	outbox, err := model.FindOutboxMessageById(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ResponseNotFound(c, "Outbox message not found.", err)
+	}
+	if err != nil {
+		return ResponseInternalServerError(c, "Find outbox message failed.", err)
+	}
+
+	// 以插件名义发送的消息只有该插件与管理员可以查看，其他消息只有管理员可以查看
+	if !auth.IsAdmin(c) {
+		if outbox.PluginID == "" {
+			return ResponseUnauthorized(c, "Admin token is required to view this message.", nil)
+		}
+		err = auth.VerifyPluginOwnership(c, outbox.PluginID)
+		if err != nil {
+			return ResponseUnauthorized(c, "A valid token of this plugin is required to view this message.", err)
+		}
+	}
+	return ResponseOK(c, outbox)
 }
@@ -0,0 +1,14 @@
+package controllers
+
+import (
+	"carrota-plugin-center/utils/logs"
+	"carrota-plugin-center/utils/metrics"
+
+	"github.com/labstack/echo/v4"
+)
+
+func MetricsGET(c echo.Context) error {
+	logs.Debug("GET /metrics")
+
+	return ResponseOK(c, metrics.Snapshot())
+}
@@ -25,3 +25,33 @@ func TokenVerificationMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 		return next(c)
 	}
 }
+
+func AdminVerificationMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !auth.IsAdmin(c) {
+			return controllers.ResponseUnauthorized(c, "Admin token required.", nil)
+		}
+		return next(c)
+	}
+}
+
+// 管理员或持有该插件凭证的调用方才能操作 URL 中 :id 对应的插件
+func PluginOwnerVerificationMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return TokenVerificationMiddleware(func(c echo.Context) error {
+		err := auth.VerifyPluginOwnership(c, c.Param("id"))
+		if err != nil {
+			return controllers.ResponseForbidden(c, "Token does not own this plugin.", err)
+		}
+		return next(c)
+	})
+}
+
+func AdminOrPluginOwnerVerificationMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	owner := PluginOwnerVerificationMiddleware(next)
+	return func(c echo.Context) error {
+		if auth.IsAdmin(c) {
+			return next(c)
+		}
+		return owner(c)
+	}
+}
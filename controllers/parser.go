@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"carrota-plugin-center/model"
+	"carrota-plugin-center/shared/service"
+	"carrota-plugin-center/utils/logs"
+	"carrota-plugin-center/utils/metrics"
+	"context"
+	"encoding/json"
+
+	"go.uber.org/zap"
+)
+
+// 将消息解析为需要触发的插件及参数
+type messageParser interface {
+	Parse(ctx context.Context, message model.MessageInfo) (model.ParserResponse, error)
+}
+
+// 请求 Parser 服务
+type httpParser struct {
+	name     string
+	endpoint string
+}
+
+func (p httpParser) Parse(ctx context.Context, message model.MessageInfo) (model.ParserResponse, error) {
+	jsonStr, _ := json.Marshal(message)
+	parserResponse := model.ParserResponse{}
+	err := postJSON(ctx, "Parser endpoint", p.endpoint, nil, service.ParserBreaker(p.name), service.ParserRetryPolicy, jsonStr, &parserResponse)
+	return parserResponse, err
+}
+
+// 按插件注册的 format 在本地匹配消息，返回所有匹配的插件
+type templateParser struct{}
+
+func (templateParser) Parse(ctx context.Context, message model.MessageInfo) (model.ParserResponse, error) {
+	scope := message.ChatScope()
+	list, err := model.FindPluginList(model.PluginListFilter{Scope: &scope})
+	if err != nil {
+		return model.ParserResponse{}, err
+	}
+	parserResponse := model.ParserResponse{Plugin: []model.ParserPluginInfo{}}
+	for _, plugin := range list.Plugins {
+		param, ok := plugin.MatchFormat(message.Message)
+		if ok {
+			parserResponse.Plugin = append(parserResponse.Plugin, model.ParserPluginInfo{ID: plugin.ID, Param: param})
+		}
+	}
+	return parserResponse, nil
+}
+
+func newMessageParser(c service.ParserConfig) messageParser {
+	if c.Type == service.ParserTypeTemplate {
+		return templateParser{}
+	}
+	return httpParser{name: c.Name, endpoint: c.Endpoint}
+}
+
+// 依次提交 Parser 链中的 Parser，请求失败时交给下一个 Parser；
+// 没有返回插件时，只有设置了 fall-through 的 Parser 才交给下一个。所有 Parser 都请求失败时返回最后一个错误
+func parseMessage(ctx context.Context, message model.MessageInfo) (model.ParserResponse, error) {
+	var lastErr error
+	succeeded := false
+	for _, c := range service.Parsers {
+		parserResponse, err := newMessageParser(c).Parse(ctx, message)
+		if err != nil {
+			logs.Warn("Parser failed, falling back to the next parser", zap.String("parser", c.Name), zap.Error(err))
+			metrics.Inc("parser_failures", c.Name)
+			lastErr = err
+			continue
+		}
+		succeeded = true
+		logs.Debug("parserResponse", zap.String("parser", c.Name), zap.Any("parserResponse", parserResponse))
+		if len(parserResponse.Plugin) > 0 {
+			metrics.Inc("parser_matches", c.Name)
+			return parserResponse, nil
+		}
+		if !c.FallThrough {
+			return parserResponse, nil
+		}
+	}
+	if !succeeded && lastErr != nil {
+		logs.Error("All parsers failed", zap.Error(lastErr))
+		return model.ParserResponse{}, lastErr
+	}
+	return model.ParserResponse{}, nil
+}
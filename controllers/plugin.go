@@ -1,12 +1,30 @@
 package controllers
 
 import (
+	"carrota-plugin-center/controllers/auth"
 	"carrota-plugin-center/model"
+	"carrota-plugin-center/shared/service"
 	"carrota-plugin-center/utils/logs"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
+type PluginRegisterResponse struct {
+	Status   string `json:"status"`
+	Token    string `json:"token,omitempty"`
+	ExpireAt int64  `json:"expire_at,omitempty"`
+}
+
 func PluginRegisterPOST(c echo.Context) error {
 	logs.Debug("POST /plugin/register")
 
@@ -16,19 +34,378 @@ func PluginRegisterPOST(c echo.Context) error {
 		return err
 	}
 
-	err = model.CreatePluginRegisterRecord(plugin)
+	err = plugin.Validate()
+	if validationErr, ok := err.(*model.ValidationError); ok {
+		logs.Info("Invalid plugin register payload.", zap.String("id", plugin.ID), zap.Error(err))
+		return ResponseValidationFailed(c, "Invalid plugin register payload.", validationErr)
+	}
+
+	// 已签发凭证的插件只能由持有凭证者或管理员更新，首次注册时签发凭证。
+	// 引入凭证前注册的旧插件没有凭证，需由管理员重新注册（或调用 /plugin/:id/token/reset）签发，以免被他人抢先注册夺走
+	registeredBy := c.RealIP()
+	response := PluginRegisterResponse{Status: "ok"}
+	newTokenID := ""
+	_, err = model.FindPluginCredential(plugin.ID)
+	switch {
+	case err == nil:
+		if auth.IsAdmin(c) {
+			registeredBy = "admin@" + registeredBy
+		} else if err := auth.VerifyPluginOwnership(c, plugin.ID); err != nil {
+			logs.Warn("Plugin register rejected, invalid plugin token.", zap.String("id", plugin.ID), zap.String("ip", c.RealIP()), zap.Error(err))
+			return ResponseUnauthorized(c, "A valid token of this plugin is required to update it.", err)
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		_, err = model.FindPluginById(plugin.ID)
+		if err == nil {
+			if !auth.IsAdmin(c) {
+				logs.Warn("Plugin register rejected, legacy plugin has no token.", zap.String("id", plugin.ID), zap.String("ip", c.RealIP()))
+				return ResponseUnauthorized(c, "Admin token is required to issue a token for a plugin registered before tokens were introduced.", nil)
+			}
+			registeredBy = "admin@" + registeredBy
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return ResponseInternalServerError(c, "Find plugin failed.", err)
+		}
+		var expireAt time.Time
+		response.Token, newTokenID, expireAt, err = auth.GeneratePluginToken()
+		if err != nil {
+			return ResponseInternalServerError(c, "Generate plugin token failed.", err)
+		}
+		response.ExpireAt = expireAt.Unix()
+	default:
+		return ResponseInternalServerError(c, "Find plugin credential failed.", err)
+	}
+
+	err = model.CreatePluginRegisterRecord(plugin, registeredBy, newTokenID)
+	if errors.Is(err, model.ErrPluginRemoved) {
+		return ResponseForbidden(c, "Plugin has been removed, restore it before registering again.", err)
+	}
+	if errors.Is(err, model.ErrPluginCredentialExists) {
+		return ResponseUnauthorized(c, "A valid token of this plugin is required to update it.", err)
+	}
 	if err != nil {
 		return ResponseInternalServerError(c, "Create PluginRegisterRecord failed.", err)
 	}
-	return ResponseOK(c, "ok")
+	prunePluginBreakers()
+	return ResponseOK(c, response)
+}
+
+// 插件被删除或更换地址后，移除不再使用的地址的熔断器
+func prunePluginBreakers() {
+	urls, err := model.FindPluginUrls()
+	if err != nil {
+		logs.Warn("Prune plugin breakers failed.", zap.Error(err))
+		return
+	}
+	service.PrunePluginBreakers(urls)
+}
+
+func parsePluginListFilter(c echo.Context) (model.PluginListFilter, error) {
+	filter := model.PluginListFilter{
+		Author:   c.QueryParam("author"),
+		IDPrefix: c.QueryParam("id_prefix"),
+		Search:   c.QueryParam("q"),
+		Status:   c.QueryParam("status"),
+		Health:   c.QueryParam("health"),
+		Sort:     c.QueryParam("sort"),
+		Cursor:   c.QueryParam("cursor"),
+	}
+	// 兼容旧参数 ?all=true
+	if filter.Status == "" && c.QueryParam("all") == "true" {
+		filter.Status = model.PluginListStatusAll
+	}
+	switch filter.Status {
+	case "", model.PluginListStatusAll, model.PluginStatusActive, model.PluginStatusDisabled:
+	default:
+		return filter, errors.New("unknown status: " + filter.Status)
+	}
+	switch filter.Health {
+	case "", model.PluginHealthUnknown, model.PluginHealthHealthy, model.PluginHealthUnhealthy:
+	default:
+		return filter, errors.New("unknown health: " + filter.Health)
+	}
+	// 指定 agent 与 group_id（私聊为 user_id）时只返回在该会话中启用的插件
+	if agent := c.QueryParam("agent"); agent != "" {
+		scope := model.NewChatScope(agent, c.QueryParam("group_id"), c.QueryParam("user_id"))
+		if scope.ChatID == "" {
+			return filter, errors.New("group_id or user_id is required with agent")
+		}
+		filter.Scope = &scope
+	}
+	if since := c.QueryParam("updated_since"); since != "" {
+		ts, err := strconv.ParseInt(since, 10, 64)
+		if err != nil {
+			return filter, err
+		}
+		t := time.Unix(ts, 0)
+		filter.UpdatedSince = &t
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return filter, err
+		}
+		filter.Limit = n
+	}
+	return filter, nil
 }
 
 func PluginListGET(c echo.Context) error {
 	logs.Debug("GET /plugin/list")
 
-	plugins, err := model.FindPluginList()
+	filter, err := parsePluginListFilter(c)
+	if err != nil {
+		return ResponseBadRequest(c, "Invalid query parameters.", err)
+	}
+	plugins, err := model.FindPluginList(filter)
+	if errors.Is(err, model.ErrInvalidPluginListFilter) {
+		return ResponseBadRequest(c, "Invalid sort, cursor or limit.", err)
+	}
 	if err != nil {
 		return ResponseInternalServerError(c, "Find plugin list failed.", err)
 	}
-	return ResponseOK(c, plugins)
-}
\ No newline at end of file
+
+	// 响应体与之前一样只包含插件数组，总数与下一页游标放在响应头中，已有的 Parser 无需修改
+	header := c.Response().Header()
+	etag := pluginListETag(plugins)
+	header.Set("ETag", etag)
+	if version, err := model.FindRegistryVersion(); err == nil {
+		header.Set("X-Registry-Version", strconv.FormatUint(uint64(version), 10))
+	}
+	header.Set("X-Total-Count", strconv.FormatInt(plugins.Total, 10))
+	if plugins.NextCursor != "" {
+		header.Set("X-Next-Cursor", plugins.NextCursor)
+	}
+	if matchETag(c.Request().Header.Get("If-None-Match"), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return ResponseOK(c, plugins.Plugins)
+}
+
+// ETag 只由插件的注册信息与状态计算，不包括每次上报与健康检查都会变化的统计字段与健康状态，
+// 插件列表未变化时返回 304，Parser 无需重新构造 prompt
+func pluginListETag(result model.PluginListResult) string {
+	type pluginETag struct {
+		model.PluginInfo
+		Status string `json:"status"`
+	}
+	entries := make([]pluginETag, 0, len(result.Plugins))
+	for _, plugin := range result.Plugins {
+		entries = append(entries, pluginETag{
+			PluginInfo: plugin.Registration(),
+			Status:     plugin.Status,
+		})
+	}
+	body, _ := json.Marshal(struct {
+		Total      int64        `json:"total"`
+		NextCursor string       `json:"next_cursor"`
+		Plugins    []pluginETag `json:"plugins"`
+	}{result.Total, result.NextCursor, entries})
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func matchETag(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func PluginGET(c echo.Context) error {
+	logs.Debug("GET /plugin/:id")
+
+	plugin, err := model.FindPluginById(c.Param("id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ResponseNotFound(c, "Plugin not found.", err)
+	}
+	if err != nil {
+		return ResponseInternalServerError(c, "Find plugin failed.", err)
+	}
+	return ResponseOK(c, plugin)
+}
+
+func PluginDELETE(c echo.Context) error {
+	logs.Debug("DELETE /plugin/:id")
+
+	err := model.DeletePluginById(c.Param("id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ResponseNotFound(c, "Plugin not found.", err)
+	}
+	if err != nil {
+		return ResponseInternalServerError(c, "Delete plugin failed.", err)
+	}
+	prunePluginBreakers()
+	return ResponseOK(c, "ok")
+}
+
+func PluginRestorePOST(c echo.Context) error {
+	logs.Debug("POST /plugin/:id/restore")
+
+	err := model.RestorePluginById(c.Param("id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ResponseNotFound(c, "Removed plugin not found.", err)
+	}
+	if err != nil {
+		return ResponseInternalServerError(c, "Restore plugin failed.", err)
+	}
+	return ResponseOK(c, "ok")
+}
+
+func PluginRevisionsGET(c echo.Context) error {
+	logs.Debug("GET /plugin/:id/revisions")
+
+	revisions, err := model.FindPluginRevisions(c.Param("id"))
+	if err != nil {
+		return ResponseInternalServerError(c, "Find plugin revisions failed.", err)
+	}
+	if len(revisions) == 0 {
+		return ResponseNotFound(c, "Plugin revisions not found.", nil)
+	}
+	return ResponseOK(c, revisions)
+}
+
+func PluginRevisionGET(c echo.Context) error {
+	logs.Debug("GET /plugin/:id/revisions/:revision")
+
+	revision, err := strconv.Atoi(c.Param("revision"))
+	if err != nil {
+		return ResponseBadRequest(c, "Invalid revision.", err)
+	}
+	r, err := model.FindPluginRevision(c.Param("id"), revision)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ResponseNotFound(c, "Plugin revision not found.", err)
+	}
+	if err != nil {
+		return ResponseInternalServerError(c, "Find plugin revision failed.", err)
+	}
+	return ResponseOK(c, r)
+}
+
+func PluginRollbackPOST(c echo.Context) error {
+	logs.Debug("POST /plugin/:id/revisions/:revision/rollback")
+
+	revision, err := strconv.Atoi(c.Param("revision"))
+	if err != nil {
+		return ResponseBadRequest(c, "Invalid revision.", err)
+	}
+	registeredBy := c.RealIP()
+	if auth.IsAdmin(c) {
+		registeredBy = "admin@" + registeredBy
+	}
+	plugin, err := model.RollbackPlugin(c.Param("id"), revision, registeredBy)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ResponseNotFound(c, "Plugin or revision not found.", err)
+	}
+	if err != nil {
+		return ResponseInternalServerError(c, "Rollback plugin failed.", err)
+	}
+	prunePluginBreakers()
+	logs.Info("Plugin rolled back.", zap.String("id", plugin.ID), zap.Int("revision", revision), zap.Int("pinnedRevision", plugin.PinnedRevision))
+	return ResponseOK(c, plugin)
+}
+
+func PluginPinDELETE(c echo.Context) error {
+	logs.Debug("DELETE /plugin/:id/pin")
+
+	err := model.UnpinPlugin(c.Param("id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ResponseNotFound(c, "Plugin not found.", err)
+	}
+	if err != nil {
+		return ResponseInternalServerError(c, "Unpin plugin failed.", err)
+	}
+	return ResponseOK(c, "ok")
+}
+
+func PluginTokenResetPOST(c echo.Context) error {
+	logs.Debug("POST /plugin/:id/token/reset")
+
+	id := c.Param("id")
+	_, err := model.FindPluginById(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ResponseNotFound(c, "Plugin not found.", err)
+	}
+	if err != nil {
+		return ResponseInternalServerError(c, "Find plugin failed.", err)
+	}
+
+	token, tokenID, expireAt, err := auth.GeneratePluginToken()
+	if err != nil {
+		return ResponseInternalServerError(c, "Generate plugin token failed.", err)
+	}
+	err = model.ResetPluginCredential(id, tokenID)
+	if err != nil {
+		return ResponseInternalServerError(c, "Reset plugin credential failed.", err)
+	}
+	logs.Info("Plugin token reset.", zap.String("id", id), zap.String("ip", c.RealIP()))
+	return ResponseOK(c, PluginRegisterResponse{
+		Status:   "ok",
+		Token:    token,
+		ExpireAt: expireAt.Unix(),
+	})
+}
+
+func PluginScopeGET(c echo.Context) error {
+	logs.Debug("GET /plugin/:id/scope")
+
+	rules, err := model.FindPluginScopeRules(c.Param("id"))
+	if err != nil {
+		return ResponseInternalServerError(c, "Find plugin scope rules failed.", err)
+	}
+	return ResponseOK(c, rules)
+}
+
+func PluginScopePUT(c echo.Context) error {
+	logs.Debug("PUT /plugin/:id/scope")
+
+	rule := model.PluginScopeRule{}
+	_ok, err := Bind(c, &rule)
+	if !_ok {
+		return err
+	}
+	rule.ID = 0
+	rule.PluginID = c.Param("id")
+	switch rule.ChatType {
+	case "", model.ChatTypeGroup, model.ChatTypePrivate:
+	default:
+		return ResponseBadRequest(c, "chat_type must be group, private or empty.", nil)
+	}
+	if rule.ChatID != "" && rule.ChatType == "" {
+		return ResponseBadRequest(c, "chat_type is required with chat_id.", nil)
+	}
+
+	_, err = model.FindPluginById(rule.PluginID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ResponseNotFound(c, "Plugin not found.", err)
+	}
+	if err != nil {
+		return ResponseInternalServerError(c, "Find plugin failed.", err)
+	}
+
+	rule, err = model.SetPluginScopeRule(rule)
+	if err != nil {
+		return ResponseInternalServerError(c, "Set plugin scope rule failed.", err)
+	}
+	logs.Info("Plugin scope rule set.", zap.Any("rule", rule))
+	return ResponseOK(c, rule)
+}
+
+func PluginScopeDELETE(c echo.Context) error {
+	logs.Debug("DELETE /plugin/:id/scope/:rule_id")
+
+	ruleID, err := strconv.ParseUint(c.Param("rule_id"), 10, 64)
+	if err != nil {
+		return ResponseBadRequest(c, "Invalid rule id.", err)
+	}
+	err = model.DeletePluginScopeRule(c.Param("id"), uint(ruleID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ResponseNotFound(c, "Plugin scope rule not found.", err)
+	}
+	if err != nil {
+		return ResponseInternalServerError(c, "Delete plugin scope rule failed.", err)
+	}
+	return ResponseOK(c, "ok")
+}
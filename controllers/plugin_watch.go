@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"carrota-plugin-center/model"
+	"carrota-plugin-center/utils/logs"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	watchPollInterval      = time.Second
+	watchHeartbeatInterval = 15 * time.Second
+	watchBatchSize         = 100
+)
+
+type PluginWatchEvent struct {
+	model.RegistryEvent
+	Plugin *model.PluginInfo `json:"plugin,omitempty"` // added、updated、enabled 事件附带插件当前信息
+}
+
+func writeWatchEvent(c echo.Context, event model.RegistryEvent) error {
+	data := PluginWatchEvent{RegistryEvent: event}
+	switch event.Type {
+	case model.RegistryEventAdded, model.RegistryEventUpdated, model.RegistryEventEnabled:
+		if plugin, err := model.FindPluginById(event.PluginID); err == nil {
+			data.Plugin = &plugin
+		}
+	}
+	body, _ := json.Marshal(data)
+	_, err := fmt.Fprintf(c.Response(), "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, body)
+	return err
+}
+
+// PluginWatchGET 以 Server-Sent Events 推送注册表变化。
+// 客户端可通过 Last-Event-ID 请求头或 since 参数从指定版本之后继续接收，不指定时只推送连接之后的变化
+func PluginWatchGET(c echo.Context) error {
+	logs.Debug("GET /plugin/watch")
+
+	since := c.Request().Header.Get("Last-Event-ID")
+	if since == "" {
+		since = c.QueryParam("since")
+	}
+	var version uint
+	if since != "" {
+		v, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			return ResponseBadRequest(c, "Invalid since or Last-Event-ID.", err)
+		}
+		version = uint(v)
+	} else {
+		v, err := model.FindRegistryVersion()
+		if err != nil {
+			return ResponseInternalServerError(c, "Find registry version failed.", err)
+		}
+		version = v
+	}
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	c.Response().WriteHeader(http.StatusOK)
+	fmt.Fprintf(c.Response(), "retry: %d\n: version %d\n\n", watchPollInterval.Milliseconds()*3, version)
+	c.Response().Flush()
+
+	ctx := c.Request().Context()
+	poll := time.NewTicker(watchPollInterval)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(watchHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Response(), ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+			c.Response().Flush()
+		case <-poll.C:
+			events, err := model.FindRegistryEvents(version, watchBatchSize)
+			if err != nil {
+				logs.Warn("Watch registry events failed.", zap.Error(err))
+				continue
+			}
+			for _, event := range events {
+				if err := writeWatchEvent(c, event); err != nil {
+					return nil
+				}
+				version = event.ID
+			}
+			if len(events) > 0 {
+				c.Response().Flush()
+			}
+		}
+	}
+}
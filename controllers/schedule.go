@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"carrota-plugin-center/controllers/auth"
+	"carrota-plugin-center/model"
+	"carrota-plugin-center/shared/service"
+	"carrota-plugin-center/utils/logs"
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const scheduleListMaxLimit = 100
+
+// PrepareScheduledMessage 将到期的定时消息经 Wrapper 包装，返回待写入 outbox 的消息，由 scheduler 调用
+func PrepareScheduledMessage(ctx context.Context, schedule model.ScheduledMessage) (model.OutboxMessage, error) {
+	target := schedule.Target()
+	policy := sendWrapperPolicy(target.ChatScope(), schedule.PluginID)
+	wrapped, err := wrapMessageWithPolicy(ctx, target, schedule.Message, policy)
+	if err != nil {
+		return model.OutboxMessage{}, err
+	}
+	return newOutboxMessage(target, wrapped, schedule.ReplyMode, schedule.PluginID, time.Now())
+}
+
+// 校验创建或修改定时消息的请求，未指定时区时使用 schedule-timezone
+func checkScheduleRequest(c echo.Context, request *model.ScheduleRequest) (bool, error) {
+	if request.Timezone == "" {
+		request.Timezone = service.ScheduleTimezone
+	}
+	err := request.Validate(time.Now())
+	if validationErr, ok := err.(*model.ValidationError); ok {
+		return false, ResponseValidationFailed(c, "Invalid schedule payload.", validationErr)
+	}
+
+	agent, err := resolveAgent(request.Agent)
+	if errors.Is(err, model.ErrUnknownAgent) {
+		return false, ResponseBadRequest(c, "Unknown agent.", err)
+	}
+	if err != nil {
+		return false, ResponseInternalServerError(c, "Find agent failed.", err)
+	}
+	err = checkAgentCapability(agent, request.ReplyMode)
+	if err != nil {
+		return false, ResponseBadRequest(c, "The agent does not support this message.", err)
+	}
+	return true, nil
+}
+
+// 查找路径参数 id 对应的定时消息，以插件名义发送的定时消息只有该插件与管理员可以访问，其他定时消息只有管理员可以访问
+func findSchedule(c echo.Context) (model.ScheduledMessage, bool, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return model.ScheduledMessage{}, false, ResponseBadRequest(c, "Invalid id.", err)
+	}
+	schedule, err := model.FindScheduledMessageById(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return schedule, false, ResponseNotFound(c, "Scheduled message not found.", err)
+	}
+	if err != nil {
+		return schedule, false, ResponseInternalServerError(c, "Find scheduled message failed.", err)
+	}
+
+	if !auth.IsAdmin(c) {
+		if schedule.PluginID == "" {
+			return schedule, false, ResponseUnauthorized(c, "Admin token is required to access this schedule.", nil)
+		}
+		err = auth.VerifyPluginOwnership(c, schedule.PluginID)
+		if err != nil {
+			return schedule, false, ResponseUnauthorized(c, "A valid token of this plugin is required to access this schedule.", err)
+		}
+	}
+	return schedule, true, nil
+}
+
+func MessageSchedulePOST(c echo.Context) error {
+	logs.Debug("POST /message/schedule")
+
+	request := model.ScheduleRequest{}
+	_ok, err := Bind(c, &request)
+	if !_ok {
+		return err
+	}
+
+	// 以插件名义发送的定时消息需携带该插件的凭证，其他定时消息需要管理员凭证，之后的查看、修改与取消同样如此
+	if !auth.IsAdmin(c) {
+		if request.PluginID == "" {
+			return ResponseUnauthorized(c, "plugin_id or the admin token is required to schedule messages.", nil)
+		}
+		err = auth.VerifyPluginOwnership(c, request.PluginID)
+		if err != nil {
+			return ResponseUnauthorized(c, "A valid token of this plugin is required to send as it.", err)
+		}
+	}
+	_ok, err = checkScheduleRequest(c, &request)
+	if !_ok {
+		return err
+	}
+
+	schedule, err := model.CreateScheduledMessage(request)
+	if err != nil {
+		return ResponseInternalServerError(c, "Create scheduled message failed.", err)
+	}
+	return ResponseOK(c, schedule)
+}
+
+func MessageSchedulesGET(c echo.Context) error {
+	logs.Debug("GET /message/schedule")
+
+	pluginID := c.QueryParam("plugin_id")
+	if pluginID == "" {
+		if !auth.IsAdmin(c) {
+			return ResponseUnauthorized(c, "Only admin can list schedules of all plugins.", nil)
+		}
+	} else if !auth.IsAdmin(c) {
+		err := auth.VerifyPluginOwnership(c, pluginID)
+		if err != nil {
+			return ResponseUnauthorized(c, "A valid token of this plugin is required to list its schedules.", err)
+		}
+	}
+
+	status := c.QueryParam("status")
+	switch status {
+	case "", model.ScheduleActive, model.ScheduleCompleted, model.ScheduleCancelled:
+	default:
+		return ResponseBadRequest(c, "Invalid status.", nil)
+	}
+	limit := 20
+	if l := c.QueryParam("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 || n > scheduleListMaxLimit {
+			return ResponseBadRequest(c, "Invalid limit.", err)
+		}
+		limit = n
+	}
+
+	schedules, err := model.FindScheduledMessages(pluginID, status, limit)
+	if err != nil {
+		return ResponseInternalServerError(c, "Find scheduled messages failed.", err)
+	}
+	return ResponseOK(c, schedules)
+}
+
+func MessageScheduleGET(c echo.Context) error {
+	logs.Debug("GET /message/schedule/:id")
+
+	schedule, _ok, err := findSchedule(c)
+	if !_ok {
+		return err
+	}
+	return ResponseOK(c, schedule)
+}
+
+func MessageSchedulePUT(c echo.Context) error {
+	logs.Debug("PUT /message/schedule/:id")
+
+	schedule, _ok, err := findSchedule(c)
+	if !_ok {
+		return err
+	}
+
+	request := model.ScheduleRequest{}
+	_ok, err = Bind(c, &request)
+	if !_ok {
+		return err
+	}
+	if request.PluginID != "" && request.PluginID != schedule.PluginID {
+		return ResponseBadRequest(c, "plugin_id cannot be changed.", nil)
+	}
+	_ok, err = checkScheduleRequest(c, &request)
+	if !_ok {
+		return err
+	}
+
+	schedule, err = model.UpdateScheduledMessage(schedule.ID, request)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ResponseNotFound(c, "Scheduled message not found.", err)
+	}
+	if errors.Is(err, model.ErrScheduleNotActive) {
+		return ResponseConflict(c, "The schedule is no longer active.", err)
+	}
+	if err != nil {
+		return ResponseInternalServerError(c, "Update scheduled message failed.", err)
+	}
+	return ResponseOK(c, schedule)
+}
+
+func MessageScheduleDELETE(c echo.Context) error {
+	logs.Debug("DELETE /message/schedule/:id")
+
+	schedule, _ok, err := findSchedule(c)
+	if !_ok {
+		return err
+	}
+
+	schedule, err = model.CancelScheduledMessage(schedule.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ResponseNotFound(c, "Scheduled message not found.", err)
+	}
+	if errors.Is(err, model.ErrScheduleNotActive) {
+		return ResponseConflict(c, "The schedule is no longer active.", err)
+	}
+	if err != nil {
+		return ResponseInternalServerError(c, "Cancel scheduled message failed.", err)
+	}
+	return ResponseOK(c, schedule)
+}
+
+func MessageScheduleRunsGET(c echo.Context) error {
+	logs.Debug("GET /message/schedule/:id/runs")
+
+	schedule, _ok, err := findSchedule(c)
+	if !_ok {
+		return err
+	}
+	limit := 20
+	if l := c.QueryParam("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 || n > scheduleListMaxLimit {
+			return ResponseBadRequest(c, "Invalid limit.", err)
+		}
+		limit = n
+	}
+
+	runs, err := model.FindScheduleRuns(schedule.ID, limit)
+	if err != nil {
+		return ResponseInternalServerError(c, "Find schedule runs failed.", err)
+	}
+	return ResponseOK(c, runs)
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/gookit/config/v2"
@@ -28,6 +30,7 @@ type YamlConfiguration struct {
 	Database             model.Database `config:"database"`
 	PluginCenterEndpoint string         `config:"plugin-center-endpoint"`
 	PluginEndpoint       string         `config:"plugin-endpoint"`
+	PluginToken          string         `config:"plugin-token"`
 }
 
 // 读取配置文件
@@ -74,11 +77,18 @@ func Bind(c echo.Context, obj interface{}) (bool, error) {
 
 type HomeworkParam struct {
 	Subject       string `json:"subject"`
-	IsAddHomework string `json:"isAddHomework"`
+	IsAddHomework bool   `json:"isAddHomework"`
 	Content       string `json:"Content"`
 	Deadline      string `json:"Deadline"`
 }
 
+// 消息片段，type 为 text、mention、image、file、quote 或 link
+type Segment struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+	Url  string `json:"url,omitempty"`
+}
+
 // Plugin Center 请求结构体
 type MessageInfo struct {
 	MessageID string        `json:"message_id"`
@@ -89,13 +99,25 @@ type MessageInfo struct {
 	UserName  string        `json:"user_name"`
 	Time      int64         `json:"time"`
 	Message   string        `json:"message"`
+	Segments  []Segment     `json:"segments"`
 	IsMention bool          `json:"is_mention"`
 	Param     HomeworkParam `json:"param"`
 }
 
+// message 中的每一项可以是字符串，也可以是片段数组
 type MessageResponse struct {
-	IsReply bool     `json:"is_reply"`
-	Message []string `json:"message"`
+	IsReply bool          `json:"is_reply"`
+	Message []interface{} `json:"message"`
+}
+
+// 消息中第一张图片的链接
+func firstImage(segments []Segment) string {
+	for _, s := range segments {
+		if s.Type == "image" {
+			return s.Url
+		}
+	}
+	return ""
 }
 
 func matchAddHomework(message string) (subject, content, deadline string, ok bool) {
@@ -136,7 +158,7 @@ func process(c echo.Context) error {
 		if !ok {
 			return c.JSON(http.StatusOK, MessageResponse{
 				IsReply: false,
-				Message: []string{},
+				Message: []interface{}{},
 			})
 		}
 		message.Param.Subject = subject_
@@ -144,38 +166,42 @@ func process(c echo.Context) error {
 		message.Param.Subject = subject_
 		message.Param.Content = content_
 		message.Param.Deadline = deadline_
-		message.Param.IsAddHomework = "True"
+		message.Param.IsAddHomework = true
 	}
 
 	content := message.Param.Content + "，截止时间：" + message.Param.Deadline
-	if message.Param.IsAddHomework == "True" || message.Param.IsAddHomework == "true" {
+	if message.Param.IsAddHomework {
+		// 添加作业时附带的截图会随查询结果一同发送
 		err := model.CreateHomeworkRecord(model.Homework{
 			Subject: message.Param.Subject,
 			Content: content,
+			Image:   firstImage(message.Segments),
 		})
 		if err != nil {
 			logs.Logs.Error("Create homework record failed.", zap.Error(err))
 			return c.JSON(http.StatusOK, MessageResponse{
 				IsReply: true,
-				Message: []string{"添加作业失败，错误原因：" + err.Error()},
+				Message: []interface{}{"添加作业失败，错误原因：" + err.Error()},
 			})
 		}
 		return c.JSON(http.StatusOK, MessageResponse{
 			IsReply: true,
-			Message: []string{"成功添加作业！作业科目：" + message.Param.Subject + "，作业内容：" + content},
+			Message: []interface{}{"成功添加作业！作业科目：" + message.Param.Subject + "，作业内容：" + content},
 		})
 	}
 
 	homework, err := model.FindHomeworkBySubject(message.Param.Subject)
-	result := "查询到的作业内容如下：\n\n"
+	result := []Segment{{Type: "text", Text: "查询到的作业内容如下：\n\n"}}
 	for _, h := range homework {
-		result += "科目：" + h.Subject + "\n"
-		result += "内容：" + h.Content + "\n\n"
+		result = append(result, Segment{Type: "text", Text: "科目：" + h.Subject + "\n" + "内容：" + h.Content + "\n\n"})
+		if h.Image != "" {
+			result = append(result, Segment{Type: "image", Url: h.Image})
+		}
 	}
 
 	return c.JSON(http.StatusOK, MessageResponse{
 		IsReply: true,
-		Message: []string{result},
+		Message: []interface{}{result},
 	})
 }
 
@@ -185,15 +211,29 @@ type PluginParam struct {
 	Description string `json:"description"`
 }
 type PluginInfo struct {
-	ID          string        `json:"id"          `
-	Name        string        `json:"name"        `
-	Author      string        `json:"author"      `
-	Description string        `json:"description" `
-	Prompt      string        `json:"prompt"      `
-	Params      []PluginParam `json:"param"       `
-	Format      []string      `json:"format"      `
-	Example     []string      `json:"example"     `
-	Url         string        `json:"url"         `
+	ID            string        `json:"id"          `
+	Name          string        `json:"name"        `
+	Author        string        `json:"author"      `
+	Description   string        `json:"description" `
+	Prompt        string        `json:"prompt"      `
+	Params        []PluginParam `json:"param"       `
+	Schema        interface{}   `json:"schema,omitempty"`
+	Format        []string      `json:"format"      `
+	Example       []string      `json:"example"     `
+	Url           string        `json:"url"         `
+	WrapperPolicy string        `json:"wrapper_policy,omitempty"`
+}
+
+// Plugin Center 签发的插件凭证
+var pluginToken string
+
+// 首次注册时签发的凭证保存到该文件，config.yml 中未设置 plugin-token 时从该文件读取
+const pluginTokenFile = "plugin-token"
+
+type RegisterResponse struct {
+	Data struct {
+		Token string `json:"token"`
+	} `json:"data"`
 }
 
 func Register(pluginCenterEndpoint string, pluginEndpoint string) error {
@@ -212,7 +252,7 @@ func Register(pluginCenterEndpoint string, pluginEndpoint string) error {
 			},
 			{
 				Key:         "isAddHomework",
-				Type:        "string",
+				Type:        "boolean",
 				Description: "语句是否有意图添加作业，或语句中是否包含“添加”",
 			},
 			{
@@ -226,6 +266,17 @@ func Register(pluginCenterEndpoint string, pluginEndpoint string) error {
 				Description: "截止时间",
 			},
 		},
+		// 通过 JSON Schema 声明参数，Plugin Center 会在上报前校验并转换 Parser 返回的参数
+		Schema: map[string]interface{}{
+			"type":     "object",
+			"required": []string{"subject", "isAddHomework"},
+			"properties": map[string]interface{}{
+				"subject":       map[string]interface{}{"type": "string", "minLength": 1},
+				"isAddHomework": map[string]interface{}{"type": "boolean", "default": false},
+				"Content":       map[string]interface{}{"type": "string"},
+				"Deadline":      map[string]interface{}{"type": "string"},
+			},
+		},
 		Format: []string{
 			"${subject}作业什么时候截止",
 			"${subject}作业截止日期",
@@ -238,9 +289,14 @@ func Register(pluginCenterEndpoint string, pluginEndpoint string) error {
 			"不调用",
 		},
 		Url: pluginEndpoint,
+		// 作业的截止时间等内容不应被 Wrapper 改写，直接发送原始回复
+		WrapperPolicy: "never",
 	})
 	req, _ := http.NewRequest("POST", pluginCenterEndpoint+"/plugin/register", bytes.NewBuffer(jsonStr))
 	req.Header.Set("Content-Type", "application/json")
+	if pluginToken != "" {
+		req.Header.Set("Authorization", "Bearer "+pluginToken)
+	}
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil || resp.StatusCode != 200 {
@@ -251,6 +307,19 @@ func Register(pluginCenterEndpoint string, pluginEndpoint string) error {
 		}
 		return err
 	}
+
+	// 首次注册时 Plugin Center 会签发插件凭证，之后更新注册信息需携带该凭证
+	registerResponse := RegisterResponse{}
+	if json.NewDecoder(resp.Body).Decode(&registerResponse) == nil && registerResponse.Data.Token != "" {
+		pluginToken = registerResponse.Data.Token
+		// 凭证不写入日志，只保存到仅当前用户可读的文件中
+		if err := os.WriteFile(pluginTokenFile, []byte(pluginToken), 0600); err != nil {
+			logs.Logs.Error("Save plugin token failed", zap.String("file", pluginTokenFile), zap.Error(err))
+		} else {
+			logs.Logs.Info("Plugin token issued and saved, keep the file or set it as plugin-token in config.yml", zap.String("file", pluginTokenFile))
+		}
+	}
+	resp.Body.Close()
 	return nil
 }
 
@@ -262,6 +331,12 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	pluginToken = config.PluginToken
+	if pluginToken == "" {
+		if token, err := os.ReadFile(pluginTokenFile); err == nil {
+			pluginToken = strings.TrimSpace(string(token))
+		}
+	}
 
 	err = model.Connect(config.Database)
 	if err != nil {
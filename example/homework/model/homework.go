@@ -9,6 +9,7 @@ import (
 type Homework struct {
 	Subject string `json:"subject" gorm:"column:subject"`
 	Content string `json:"content" gorm:"column:content"`
+	Image   string `json:"image"   gorm:"column:image"` // 作业截图链接
 }
 
 func CreateHomeworkRecord(homework Homework) error {
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/gookit/config/v2"
@@ -35,6 +37,7 @@ type YamlConfiguration struct {
 	Database             DatabaseConfig `config:"database"`
 	PluginCenterEndpoint string         `config:"plugin-center-endpoint"`
 	PluginEndpoint       string         `config:"plugin-endpoint"`
+	PluginToken          string         `config:"plugin-token"`
 }
 
 // 读取配置文件
@@ -79,18 +82,26 @@ func Bind(c echo.Context, obj interface{}) (bool, error) {
 	return true, nil
 }
 
+// Plugin Center 附带的会话历史消息
+type HistoryMessage struct {
+	Role    string `json:"role"` // user 或 bot
+	UserID  string `json:"user_id"`
+	Message string `json:"message"`
+}
+
 // Plugin Center 请求结构体
 type MessageInfo struct {
-	MessageID string      `json:"message_id"`
-	Agent     string      `json:"agent"`
-	GroupID   string      `json:"group_id"`
-	GroupName string      `json:"group_name"`
-	UserID    string      `json:"user_id"`
-	UserName  string      `json:"user_name"`
-	Time      int64       `json:"time"`
-	Message   string      `json:"message"`
-	IsMention bool        `json:"is_mention"`
-	Param     interface{} `json:"param"`
+	MessageID string           `json:"message_id"`
+	Agent     string           `json:"agent"`
+	GroupID   string           `json:"group_id"`
+	GroupName string           `json:"group_name"`
+	UserID    string           `json:"user_id"`
+	UserName  string           `json:"user_name"`
+	Time      int64            `json:"time"`
+	Message   string           `json:"message"`
+	IsMention bool             `json:"is_mention"`
+	History   []HistoryMessage `json:"history"`
+	Param     interface{}      `json:"param"`
 }
 
 type MessageResponse struct {
@@ -98,8 +109,6 @@ type MessageResponse struct {
 	Message []string `json:"message"`
 }
 
-var messageRecord = make(map[string][]MessageInfo)
-
 const repeatCount = 3
 
 func process(c echo.Context) error {
@@ -122,15 +131,21 @@ func process(c echo.Context) error {
 		})
 	}
 
-	messageRecord[message.GroupID] = append(messageRecord[message.GroupID], message)
-	if l := len(messageRecord[message.GroupID]); l >= repeatCount {
-		if messageRecord[message.GroupID][l-1].Message == messageRecord[message.GroupID][l-2].Message &&
-			messageRecord[message.GroupID][l-2].Message == messageRecord[message.GroupID][l-3].Message {
-			reply := []string{messageRecord[message.GroupID][l-1].Message}
-			messageRecord[message.GroupID] = []MessageInfo{}
+	// 会话历史由 Plugin Center 维护，最近 repeatCount-1 条都是与当前相同的用户消息时复读；
+	// 复读后机器人的回复会出现在历史中，因此不会连续复读
+	history := message.History
+	if l := len(history); l >= repeatCount-1 {
+		repeat := true
+		for _, h := range history[l-(repeatCount-1):] {
+			if h.Role != "user" || h.Message != message.Message {
+				repeat = false
+				break
+			}
+		}
+		if repeat {
 			return c.JSON(http.StatusOK, MessageResponse{
 				IsReply: true,
-				Message: reply,
+				Message: []string{message.Message},
 			})
 		}
 	}
@@ -157,6 +172,18 @@ type PluginInfo struct {
 	Url         string        `json:"url"         `
 }
 
+// Plugin Center 签发的插件凭证
+var pluginToken string
+
+// 首次注册时签发的凭证保存到该文件，config.yml 中未设置 plugin-token 时从该文件读取
+const pluginTokenFile = "plugin-token"
+
+type RegisterResponse struct {
+	Data struct {
+		Token string `json:"token"`
+	} `json:"data"`
+}
+
 func Register(pluginCenterEndpoint string, pluginEndpoint string) error {
 	/****************** 注册插件 ******************/
 	jsonStr, _ := json.Marshal(PluginInfo{
@@ -174,6 +201,9 @@ func Register(pluginCenterEndpoint string, pluginEndpoint string) error {
 	})
 	req, _ := http.NewRequest("POST", pluginCenterEndpoint+"/plugin/register", bytes.NewBuffer(jsonStr))
 	req.Header.Set("Content-Type", "application/json")
+	if pluginToken != "" {
+		req.Header.Set("Authorization", "Bearer "+pluginToken)
+	}
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil || resp.StatusCode != 200 {
@@ -184,6 +214,19 @@ func Register(pluginCenterEndpoint string, pluginEndpoint string) error {
 		}
 		return err
 	}
+
+	// 首次注册时 Plugin Center 会签发插件凭证，之后更新注册信息需携带该凭证
+	registerResponse := RegisterResponse{}
+	if json.NewDecoder(resp.Body).Decode(&registerResponse) == nil && registerResponse.Data.Token != "" {
+		pluginToken = registerResponse.Data.Token
+		// 凭证不写入日志，只保存到仅当前用户可读的文件中
+		if err := os.WriteFile(pluginTokenFile, []byte(pluginToken), 0600); err != nil {
+			logs.Error("Save plugin token failed", zap.String("file", pluginTokenFile), zap.Error(err))
+		} else {
+			logs.Info("Plugin token issued and saved, keep the file or set it as plugin-token in config.yml", zap.String("file", pluginTokenFile))
+		}
+	}
+	resp.Body.Close()
 	return nil
 }
 
@@ -196,6 +239,12 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	pluginToken = config.PluginToken
+	if pluginToken == "" {
+		if token, err := os.ReadFile(pluginTokenFile); err == nil {
+			pluginToken = strings.TrimSpace(string(token))
+		}
+	}
 
 	err = Register(config.PluginCenterEndpoint, config.PluginEndpoint)
 	if err != nil {
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"os"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/gookit/config/v2"
@@ -37,6 +39,7 @@ type YamlConfiguration struct {
 	Database             DatabaseConfig `config:"database"`
 	PluginCenterEndpoint string         `config:"plugin-center-endpoint"`
 	PluginEndpoint       string         `config:"plugin-endpoint"`
+	PluginToken          string         `config:"plugin-token"`
 	QWeatherToken        string         `config:"qweather-token"`
 }
 
@@ -279,6 +282,18 @@ type PluginInfo struct {
 	Url         string        `json:"url"        `
 }
 
+// Plugin Center 签发的插件凭证
+var pluginToken string
+
+// 首次注册时签发的凭证保存到该文件，config.yml 中未设置 plugin-token 时从该文件读取
+const pluginTokenFile = "plugin-token"
+
+type RegisterResponse struct {
+	Data struct {
+		Token string `json:"token"`
+	} `json:"data"`
+}
+
 func Register(pluginCenterEndpoint string, pluginEndpoint string) error {
 	/****************** 注册插件 ******************/
 	jsonStr, _ := json.Marshal(PluginInfo{
@@ -311,6 +326,9 @@ func Register(pluginCenterEndpoint string, pluginEndpoint string) error {
 	})
 	req, _ := http.NewRequest("POST", pluginCenterEndpoint+"/plugin/register", bytes.NewBuffer(jsonStr))
 	req.Header.Set("Content-Type", "application/json")
+	if pluginToken != "" {
+		req.Header.Set("Authorization", "Bearer "+pluginToken)
+	}
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil || resp.StatusCode != 200 {
@@ -321,6 +339,19 @@ func Register(pluginCenterEndpoint string, pluginEndpoint string) error {
 		}
 		return err
 	}
+
+	// 首次注册时 Plugin Center 会签发插件凭证，之后更新注册信息需携带该凭证
+	registerResponse := RegisterResponse{}
+	if json.NewDecoder(resp.Body).Decode(&registerResponse) == nil && registerResponse.Data.Token != "" {
+		pluginToken = registerResponse.Data.Token
+		// 凭证不写入日志，只保存到仅当前用户可读的文件中
+		if err := os.WriteFile(pluginTokenFile, []byte(pluginToken), 0600); err != nil {
+			logs.Error("Save plugin token failed", zap.String("file", pluginTokenFile), zap.Error(err))
+		} else {
+			logs.Info("Plugin token issued and saved, keep the file or set it as plugin-token in config.yml", zap.String("file", pluginTokenFile))
+		}
+	}
+	resp.Body.Close()
 	return nil
 }
 
@@ -333,6 +364,12 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	pluginToken = config.PluginToken
+	if pluginToken == "" {
+		if token, err := os.ReadFile(pluginTokenFile); err == nil {
+			pluginToken = strings.TrimSpace(string(token))
+		}
+	}
 
 	qweatherToken = config.QWeatherToken
 
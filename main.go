@@ -1,9 +1,15 @@
 package main
 
 import (
+	"carrota-plugin-center/controllers"
 	"carrota-plugin-center/controllers/auth"
 	"carrota-plugin-center/model"
 	"carrota-plugin-center/shared/config"
+	"carrota-plugin-center/shared/idempotency"
+	"carrota-plugin-center/shared/outbox"
+	"carrota-plugin-center/shared/probe"
+	"carrota-plugin-center/shared/queue"
+	"carrota-plugin-center/shared/scheduler"
 	"carrota-plugin-center/shared/server"
 	"carrota-plugin-center/shared/service"
 )
@@ -30,11 +36,32 @@ func main() {
 		panic(err)
 	}
 
+	agents := []model.AgentInfo{}
+	for _, agent := range service.Agents {
+		agents = append(agents, model.AgentInfo{
+			Name:           agent.Name,
+			Endpoint:       agent.Endpoint,
+			RecallEndpoint: agent.RecallEndpoint,
+			Token:          agent.Token,
+			Capabilities:   agent.Capabilities,
+		})
+	}
+	err = model.InitAgents(agents)
+	if err != nil {
+		panic(err)
+	}
+
 	err = auth.InitAuthorization(configuration.Authorization)
 	if err != nil {
 		panic(err)
 	}
 
+	go probe.Run()
+	outbox.Run(controllers.DeliverOutboxMessage)
+	queue.Run(controllers.ProcessUserMessage)
+	scheduler.Run(controllers.PrepareScheduledMessage)
+	idempotency.Run()
+
 	err = server.Run(configuration.Server)
 	if err != nil {
 		panic(err)
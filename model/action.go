@@ -0,0 +1,93 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	ActionReply   = "reply"   // 按插件的 reply_mode 回复当前会话，经过 Wrapper 包装
+	ActionSend    = "send"    // 发送到指定的群聊或用户
+	ActionDelay   = "delay"   // 延迟一段时间后发送，未指定目标时发送到当前会话
+	ActionRecall  = "recall"  // 撤回机器人发送过的消息
+	ActionMention = "mention" // 在当前会话中 @ 指定用户并发送消息
+)
+
+// 延迟发送的最长时间（秒）
+const ActionMaxDelay = 7 * 24 * 60 * 60
+
+// PluginAction 为插件回复中要求 Plugin Center 执行的动作
+type PluginAction struct {
+	Type      string        `json:"type"`
+	Message   []RichMessage `json:"message,omitempty"`
+	Agent     string        `json:"agent,omitempty"`
+	GroupID   string        `json:"group_id,omitempty"`
+	UserID    string        `json:"user_id,omitempty"`
+	UserIDs   []string      `json:"user_ids,omitempty"`
+	MessageID string        `json:"message_id,omitempty"`
+	Delay     int           `json:"delay,omitempty"` // 秒
+}
+
+var ErrInvalidAction = errors.New("invalid plugin action")
+
+var ErrActionScope = errors.New("plugin is not enabled in the target chat")
+
+var ErrRecallNotOwned = errors.New("message was not sent for this plugin")
+
+func (a PluginAction) Validate() error {
+	switch a.Type {
+	case ActionReply:
+		if len(a.Message) == 0 {
+			return fmt.Errorf("%w: reply requires message", ErrInvalidAction)
+		}
+	case ActionSend:
+		if len(a.Message) == 0 || (a.GroupID == "" && a.UserID == "") {
+			return fmt.Errorf("%w: send requires message and group_id or user_id", ErrInvalidAction)
+		}
+	case ActionDelay:
+		if len(a.Message) == 0 || a.Delay <= 0 || a.Delay > ActionMaxDelay {
+			return fmt.Errorf("%w: delay requires message and delay between 1 and %d seconds", ErrInvalidAction, ActionMaxDelay)
+		}
+	case ActionRecall:
+		if a.MessageID == "" {
+			return fmt.Errorf("%w: recall requires message_id", ErrInvalidAction)
+		}
+	case ActionMention:
+		if len(a.UserIDs) == 0 {
+			return fmt.Errorf("%w: mention requires user_ids", ErrInvalidAction)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidAction, a.Type)
+	}
+	return nil
+}
+
+// Target 返回动作的目标会话，未指定的字段使用原消息的会话
+func (a PluginAction) Target(origin MessageInfo) MessageInfo {
+	target := MessageInfo{
+		Agent:   origin.Agent,
+		GroupID: origin.GroupID,
+		UserID:  origin.UserID,
+	}
+	if a.Agent != "" {
+		target.Agent = a.Agent
+	}
+	if a.GroupID != "" || a.UserID != "" {
+		target.GroupID = a.GroupID
+		target.UserID = a.UserID
+	}
+	return target
+}
+
+// AgentRecallRequest 为 Plugin Center 请求 Agent 撤回消息的格式
+type AgentRecallRequest struct {
+	Agent     string `json:"agent"`
+	MessageID string `json:"message_id"`
+	GroupID   string `json:"group_id"`
+	UserID    string `json:"user_id"`
+}
+
+// AgentSendResponse 为 Agent 发送消息后的可选响应，包含机器人发送的消息 ID，用于之后撤回
+type AgentSendResponse struct {
+	MessageID []string `json:"message_id"`
+}
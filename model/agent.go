@@ -0,0 +1,234 @@
+package model
+
+import (
+	"carrota-plugin-center/utils/logs"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	AgentCapabilitySegments = "segments" // 支持图片、@ 等富文本片段，不支持时只发送纯文本
+	AgentCapabilityRecall   = "recall"   // 支持撤回消息
+	AgentCapabilityPrivate  = "private"  // 支持私聊发送
+)
+
+var AgentCapabilities = []string{AgentCapabilitySegments, AgentCapabilityRecall, AgentCapabilityPrivate}
+
+var ErrUnknownAgent = errors.New("unknown agent")
+var ErrAgentCapability = errors.New("agent does not support this operation")
+
+// Agent 为一个 Agent 部署，消息按 MessageInfo.Agent 路由到同名的 Agent
+type Agent struct {
+	Name           string         `json:"name"            gorm:"primaryKey"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	Endpoint       string         `json:"endpoint"        gorm:"not null"`
+	RecallEndpoint string         `json:"recall_endpoint" gorm:"not null;default:''"` // 为空时使用 endpoint + "/recall"
+	Token          string         `json:"-"               gorm:"not null;default:''"` // 请求 Agent 时以 Authorization: Bearer 发送
+	Capabilities   pq.StringArray `json:"capabilities"    gorm:"type:text[]"`
+}
+
+// AgentInfo 为 Agent 的注册与展示格式，token 只写不读
+type AgentInfo struct {
+	Name           string    `json:"name"`
+	CreatedAt      time.Time `json:"created_at,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at,omitempty"`
+	Endpoint       string    `json:"endpoint"`
+	RecallEndpoint string    `json:"recall_endpoint"`
+	Token          string    `json:"token,omitempty"`
+	HasToken       bool      `json:"has_token"`
+	Capabilities   []string  `json:"capabilities"`
+}
+
+func (a Agent) Info() AgentInfo {
+	capabilities := []string(a.Capabilities)
+	if capabilities == nil {
+		capabilities = []string{}
+	}
+	return AgentInfo{
+		Name:           a.Name,
+		CreatedAt:      a.CreatedAt,
+		UpdatedAt:      a.UpdatedAt,
+		Endpoint:       a.Endpoint,
+		RecallEndpoint: a.RecallEndpoint,
+		HasToken:       a.Token != "",
+		Capabilities:   capabilities,
+	}
+}
+
+func (a AgentInfo) record() Agent {
+	return Agent{
+		Name:           a.Name,
+		Endpoint:       a.Endpoint,
+		RecallEndpoint: a.RecallEndpoint,
+		Token:          a.Token,
+		Capabilities:   pq.StringArray(a.Capabilities),
+	}
+}
+
+// Validate 校验 Agent 信息，校验失败时返回 *ValidationError
+func (a *AgentInfo) Validate() error {
+	e := &ValidationError{}
+
+	a.Name = strings.TrimSpace(a.Name)
+	if a.Name == "" {
+		e.add("name", "is required")
+	}
+	if a.Endpoint == "" {
+		e.add("endpoint", "is required")
+	} else if !isHTTPURL(a.Endpoint) {
+		e.add("endpoint", "must be an absolute http or https URL")
+	}
+	if a.RecallEndpoint != "" && !isHTTPURL(a.RecallEndpoint) {
+		e.add("recall_endpoint", "must be an absolute http or https URL")
+	}
+	for _, capability := range a.Capabilities {
+		if !IsValidAgentCapability(capability) {
+			e.add("capabilities", "unknown capability %q, must be one of %s", capability, strings.Join(AgentCapabilities, ", "))
+		}
+	}
+
+	if len(e.Fields) > 0 {
+		return e
+	}
+	return nil
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.ParseRequestURI(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func IsValidAgentCapability(capability string) bool {
+	for _, c := range AgentCapabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+func (a Agent) Can(capability string) bool {
+	for _, c := range a.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// RecallUrl 为 Agent 的撤回接口地址
+func (a Agent) RecallUrl() string {
+	if a.RecallEndpoint != "" {
+		return a.RecallEndpoint
+	}
+	return strings.TrimSuffix(a.Endpoint, "/") + "/recall"
+}
+
+func FindAgentByName(name string) (Agent, error) {
+	m := GetModel()
+	defer m.Close()
+
+	agent := Agent{}
+	result := m.tx.Where("name = ?", name).First(&agent)
+	if result.Error != nil {
+		if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			logs.Info("Find agent failed.", zap.String("name", name), zap.Error(result.Error))
+		}
+		m.Abort()
+		return Agent{}, result.Error
+	}
+
+	m.tx.Commit()
+	return agent, nil
+}
+
+func FindAgents() ([]Agent, error) {
+	m := GetModel()
+	defer m.Close()
+
+	agents := []Agent{}
+	result := m.tx.Order("name").Find(&agents)
+	if result.Error != nil {
+		logs.Info("Find agents failed.", zap.Error(result.Error))
+		m.Abort()
+		return nil, result.Error
+	}
+
+	m.tx.Commit()
+	return agents, nil
+}
+
+// SaveAgent 创建或更新 Agent，token 为空时保留原有的 token
+func SaveAgent(info AgentInfo) (Agent, error) {
+	m := GetModel()
+	defer m.Close()
+
+	agent := info.record()
+	columns := []string{"endpoint", "recall_endpoint", "capabilities", "updated_at"}
+	if agent.Token != "" {
+		columns = append(columns, "token")
+	}
+	result := m.tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns(columns),
+	}).Create(&agent)
+	if result.Error != nil {
+		logs.Warn("Save agent failed.", zap.String("name", agent.Name), zap.Error(result.Error))
+		m.Abort()
+		return Agent{}, result.Error
+	}
+
+	saved := Agent{}
+	result = m.tx.Where("name = ?", agent.Name).First(&saved)
+	if result.Error != nil {
+		logs.Warn("Find saved agent failed.", zap.String("name", agent.Name), zap.Error(result.Error))
+		m.Abort()
+		return Agent{}, result.Error
+	}
+
+	m.tx.Commit()
+	return saved, nil
+}
+
+func DeleteAgentByName(name string) error {
+	m := GetModel()
+	defer m.Close()
+
+	result := m.tx.Where("name = ?", name).Delete(&Agent{})
+	if result.Error != nil {
+		logs.Info("Delete agent failed.", zap.String("name", name), zap.Error(result.Error))
+		m.Abort()
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		m.Abort()
+		return gorm.ErrRecordNotFound
+	}
+
+	m.tx.Commit()
+	return nil
+}
+
+// InitAgents 将配置文件中的 Agent 写入注册表，配置文件中的字段覆盖通过接口修改的值
+func InitAgents(agents []AgentInfo) error {
+	for _, agent := range agents {
+		err := agent.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid agent %q in config: %w", agent.Name, err)
+		}
+		_, err = SaveAgent(agent)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
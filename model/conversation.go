@@ -0,0 +1,145 @@
+package model
+
+import (
+	"carrota-plugin-center/utils/logs"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	ConversationRoleUser = "user"
+	ConversationRoleBot  = "bot"
+)
+
+// HistoryMessage 为会话中的一条历史消息，随 Parser 与插件请求一同发送
+type HistoryMessage struct {
+	Role      string `json:"role"`
+	MessageID string `json:"message_id,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+	UserName  string `json:"user_name,omitempty"`
+	Message   string `json:"message"`
+	Time      int64  `json:"time"`
+}
+
+// ConversationMessage 记录每个会话最近的用户消息与机器人回复
+type ConversationMessage struct {
+	ID        uint      `json:"id"         gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	Agent     string    `json:"agent"      gorm:"not null;index:idx_conversation_chat,priority:1"`
+	ChatType  string    `json:"chat_type"  gorm:"not null;index:idx_conversation_chat,priority:2"`
+	ChatID    string    `json:"chat_id"    gorm:"not null;index:idx_conversation_chat,priority:3"`
+	Role      string    `json:"role"       gorm:"not null"`
+	MessageID string    `json:"message_id" gorm:"not null;default:''"`
+	UserID    string    `json:"user_id"    gorm:"not null;default:''"`
+	UserName  string    `json:"user_name"  gorm:"not null;default:''"`
+	Message   string    `json:"message"    gorm:"type:text;not null"`
+	Time      int64     `json:"time"       gorm:"not null"`
+}
+
+func (c ConversationMessage) History() HistoryMessage {
+	return HistoryMessage{
+		Role:      c.Role,
+		MessageID: c.MessageID,
+		UserID:    c.UserID,
+		UserName:  c.UserName,
+		Message:   c.Message,
+		Time:      c.Time,
+	}
+}
+
+// RecordConversationMessages 追加会话消息，并只保留该会话最近的 keep 条。
+// 消息重新处理时，已经记录过的用户消息（相同 message_id）不会重复记录
+func RecordConversationMessages(scope ChatScope, messages []HistoryMessage, keep int) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	m := GetModel()
+	defer m.Close()
+
+	messageIDs := []string{}
+	for _, message := range messages {
+		if message.Role == ConversationRoleUser && message.MessageID != "" {
+			messageIDs = append(messageIDs, message.MessageID)
+		}
+	}
+	recorded := map[string]bool{}
+	if len(messageIDs) > 0 {
+		existing := []string{}
+		result := m.tx.Model(&ConversationMessage{}).
+			Where("agent = ? AND chat_type = ? AND chat_id = ? AND role = ?", scope.Agent, scope.ChatType, scope.ChatID, ConversationRoleUser).
+			Where("message_id IN ?", messageIDs).Pluck("message_id", &existing)
+		if result.Error != nil {
+			logs.Warn("Find recorded conversation messages failed.", zap.Any("scope", scope), zap.Error(result.Error))
+			m.Abort()
+			return result.Error
+		}
+		for _, id := range existing {
+			recorded[id] = true
+		}
+	}
+
+	records := make([]ConversationMessage, 0, len(messages))
+	for _, message := range messages {
+		if message.Role == ConversationRoleUser && recorded[message.MessageID] {
+			continue
+		}
+		records = append(records, ConversationMessage{
+			Agent:     scope.Agent,
+			ChatType:  scope.ChatType,
+			ChatID:    scope.ChatID,
+			Role:      message.Role,
+			MessageID: message.MessageID,
+			UserID:    message.UserID,
+			UserName:  message.UserName,
+			Message:   message.Message,
+			Time:      message.Time,
+		})
+	}
+	if len(records) == 0 {
+		m.tx.Commit()
+		return nil
+	}
+	result := m.tx.Create(&records)
+	if result.Error != nil {
+		logs.Warn("Create conversation messages failed.", zap.Any("scope", scope), zap.Error(result.Error))
+		m.Abort()
+		return result.Error
+	}
+
+	kept := m.tx.Model(&ConversationMessage{}).Select("id").
+		Where("agent = ? AND chat_type = ? AND chat_id = ?", scope.Agent, scope.ChatType, scope.ChatID).
+		Order("id DESC").Limit(keep)
+	result = m.tx.Where("agent = ? AND chat_type = ? AND chat_id = ?", scope.Agent, scope.ChatType, scope.ChatID).
+		Where("id NOT IN (?)", kept).Delete(&ConversationMessage{})
+	if result.Error != nil {
+		logs.Warn("Prune conversation messages failed.", zap.Any("scope", scope), zap.Error(result.Error))
+		m.Abort()
+		return result.Error
+	}
+
+	m.tx.Commit()
+	return nil
+}
+
+// FindConversationHistory 返回会话最近的 limit 条消息，按时间先后排列
+func FindConversationHistory(scope ChatScope, limit int) ([]HistoryMessage, error) {
+	m := GetModel()
+	defer m.Close()
+
+	records := []ConversationMessage{}
+	result := m.tx.Where("agent = ? AND chat_type = ? AND chat_id = ?", scope.Agent, scope.ChatType, scope.ChatID).
+		Order("id DESC").Limit(limit).Find(&records)
+	if result.Error != nil {
+		logs.Info("Find conversation history failed.", zap.Any("scope", scope), zap.Error(result.Error))
+		m.Abort()
+		return nil, result.Error
+	}
+
+	m.tx.Commit()
+	history := make([]HistoryMessage, len(records))
+	for i, record := range records {
+		history[len(records)-1-i] = record.History()
+	}
+	return history, nil
+}
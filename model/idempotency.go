@@ -0,0 +1,119 @@
+package model
+
+import (
+	"carrota-plugin-center/utils/logs"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	IdempotencyScopeMessage = "message"
+	IdempotencyScopeSend    = "send"
+)
+
+// IdempotencyRecord 记录请求的处理结果，相同 (Scope, Key) 的请求在 ExpireAt 前直接返回该结果。
+// StatusCode 为 0 表示首次请求仍在处理中，此时 ExpireAt 为处理的租约，进程退出导致未完成的记录在租约过期后清除
+type IdempotencyRecord struct {
+	Scope       string    `json:"scope"        gorm:"primaryKey"`
+	Key         string    `json:"key"          gorm:"primaryKey"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpireAt    time.Time `json:"expire_at"    gorm:"not null;index"`
+	StatusCode  int       `json:"status_code"  gorm:"not null;default:0"`
+	ContentType string    `json:"content_type" gorm:"not null;default:''"`
+	Body        string    `json:"body"         gorm:"type:text;not null;default:''"`
+}
+
+// BeginIdempotentRequest 占用 (scope, key) lease 时长，首次请求返回 created 为 true，
+// 重复请求返回已有的记录。(scope, key) 的过期记录会在此时清除，其他过期记录由 DeleteExpiredIdempotencyRecords 定期清除
+func BeginIdempotentRequest(scope string, key string, lease time.Duration) (record IdempotencyRecord, created bool, err error) {
+	m := GetModel()
+	defer m.Close()
+
+	result := m.tx.Where("scope = ? AND key = ? AND expire_at < ?", scope, key, time.Now()).Delete(&IdempotencyRecord{})
+	if result.Error != nil {
+		logs.Warn("Delete expired idempotency record failed.", zap.String("scope", scope), zap.String("key", key), zap.Error(result.Error))
+		m.Abort()
+		return record, false, result.Error
+	}
+
+	record = IdempotencyRecord{
+		Scope:    scope,
+		Key:      key,
+		ExpireAt: time.Now().Add(lease),
+	}
+	result = m.tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if result.Error != nil {
+		logs.Warn("Create idempotency record failed.", zap.String("scope", scope), zap.String("key", key), zap.Error(result.Error))
+		m.Abort()
+		return record, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		m.tx.Commit()
+		return record, true, nil
+	}
+
+	result = m.tx.Where("scope = ? AND key = ?", scope, key).First(&record)
+	if result.Error != nil {
+		logs.Warn("Find idempotency record failed.", zap.String("scope", scope), zap.String("key", key), zap.Error(result.Error))
+		m.Abort()
+		return record, false, result.Error
+	}
+
+	m.tx.Commit()
+	return record, false, nil
+}
+
+// FinishIdempotentRequest 保存首次请求的响应，供 retention 时长内的重复请求返回
+func FinishIdempotentRequest(scope string, key string, statusCode int, contentType string, body string, retention time.Duration) error {
+	m := GetModel()
+	defer m.Close()
+
+	result := m.tx.Model(&IdempotencyRecord{}).Where("scope = ? AND key = ?", scope, key).Updates(map[string]interface{}{
+		"status_code":  statusCode,
+		"content_type": contentType,
+		"body":         body,
+		"expire_at":    time.Now().Add(retention),
+	})
+	if result.Error != nil {
+		logs.Warn("Finish idempotency record failed.", zap.String("scope", scope), zap.String("key", key), zap.Error(result.Error))
+		m.Abort()
+		return result.Error
+	}
+
+	m.tx.Commit()
+	return nil
+}
+
+// AbortIdempotentRequest 释放 (scope, key)，用于首次请求失败、允许客户端重试的情况
+func AbortIdempotentRequest(scope string, key string) error {
+	m := GetModel()
+	defer m.Close()
+
+	result := m.tx.Where("scope = ? AND key = ?", scope, key).Delete(&IdempotencyRecord{})
+	if result.Error != nil {
+		logs.Warn("Delete idempotency record failed.", zap.String("scope", scope), zap.String("key", key), zap.Error(result.Error))
+		m.Abort()
+		return result.Error
+	}
+
+	m.tx.Commit()
+	return nil
+}
+
+// DeleteExpiredIdempotencyRecords 删除 before 之前过期的记录，返回删除的条数
+func DeleteExpiredIdempotencyRecords(before time.Time) (int64, error) {
+	m := GetModel()
+	defer m.Close()
+
+	result := m.tx.Where("expire_at < ?", before).Delete(&IdempotencyRecord{})
+	if result.Error != nil {
+		logs.Warn("Delete expired idempotency records failed.", zap.Error(result.Error))
+		m.Abort()
+		return 0, result.Error
+	}
+
+	m.tx.Commit()
+	return result.RowsAffected, nil
+}
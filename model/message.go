@@ -1,20 +1,63 @@
 package model
 
+// 插件回复的发送方式
+const (
+	ReplyModeReply   = "reply"   // 引用原消息回复
+	ReplyModeGroup   = "group"   // 直接发送到群聊，不引用原消息
+	ReplyModePrivate = "private" // 私聊发送给消息的发送者
+	ReplyModeSilent  = "silent"  // 不发送回复
+)
+
+// 按发送顺序排列的回复方式
+var ReplyModes = []string{ReplyModeReply, ReplyModeGroup, ReplyModePrivate, ReplyModeSilent}
+
+func IsValidReplyMode(mode string) bool {
+	for _, m := range ReplyModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
 type MessageInfo struct {
-	MessageID string `json:"message_id"`
-	Agent     string `json:"agent"`
-	GroupID   string `json:"group_id"`
-	GroupName string `json:"group_name"`
-	UserID    string `json:"user_id"`
-	UserName  string `json:"user_name"`
-	Time      int64  `json:"time"`
-	Message   string `json:"message"`
-	IsMention bool   `json:"is_mention"`
+	MessageID string           `json:"message_id"`
+	Agent     string           `json:"agent"`
+	GroupID   string           `json:"group_id"`
+	GroupName string           `json:"group_name"`
+	UserID    string           `json:"user_id"`
+	UserName  string           `json:"user_name"`
+	Time      int64            `json:"time"`
+	Message   string           `json:"message"`
+	Segments  []Segment        `json:"segments,omitempty"`
+	IsMention bool             `json:"is_mention"`
+	History   []HistoryMessage `json:"history,omitempty"`
+}
+
+// NormalizeSegments 校验消息片段，并保证 Message 与 Segments 同时存在：
+// 只提供 Segments 时以其纯文本形式填充 Message，只提供 Message 时生成一个 text 片段
+func (m *MessageInfo) NormalizeSegments() error {
+	for _, s := range m.Segments {
+		if !s.valid() {
+			return ErrInvalidSegment
+		}
+	}
+	if len(m.Segments) == 0 {
+		if m.Message != "" {
+			m.Segments = TextMessage(m.Message)
+		}
+		return nil
+	}
+	if m.Message == "" {
+		m.Message = RichMessage(m.Segments).PlainText()
+	}
+	return nil
 }
 
 type MessageReply struct {
-	IsReply bool     `json:"is_reply"`
-	Message []string `json:"message"`
+	IsReply bool           `json:"is_reply"`
+	Message []RichMessage  `json:"message"`
+	Actions []PluginAction `json:"actions,omitempty"`
 }
 
 type ParserPluginInfo struct {
@@ -27,37 +70,47 @@ type ParserResponse struct {
 }
 
 type PostPluginRequest struct {
-	MessageID string      `json:"message_id"`
-	Agent     string      `json:"agent"`
-	GroupID   string      `json:"group_id"`
-	GroupName string      `json:"group_name"`
-	UserID    string      `json:"user_id"`
-	UserName  string      `json:"user_name"`
-	Time      int64       `json:"time"`
-	Message   string      `json:"message"`
-	IsMention bool        `json:"is_mention"`
-	Param     interface{} `json:"param"`
+	MessageID string           `json:"message_id"`
+	Agent     string           `json:"agent"`
+	GroupID   string           `json:"group_id"`
+	GroupName string           `json:"group_name"`
+	UserID    string           `json:"user_id"`
+	UserName  string           `json:"user_name"`
+	Time      int64            `json:"time"`
+	Message   string           `json:"message"`
+	Segments  []Segment        `json:"segments,omitempty"`
+	IsMention bool             `json:"is_mention"`
+	History   []HistoryMessage `json:"history,omitempty"`
+	Param     interface{}      `json:"param"`
 }
 
+// Segments 与 OriginalSegments 分别为原消息与插件回复的片段形式，Message 与 OriginalResponse 为其纯文本形式
 type PostWrapperRequest struct {
-	Agent            string   `json:"agent"`
-	GroupID          string   `json:"group_id"`
-	GroupName        string   `json:"group_name"`
-	UserID           string   `json:"user_id"`
-	UserName         string   `json:"user_name"`
-	Time             int64    `json:"time"`
-	Message          string   `json:"message"`
-	OriginalResponse []string `json:"original_response"`
+	Agent            string        `json:"agent"`
+	GroupID          string        `json:"group_id"`
+	GroupName        string        `json:"group_name"`
+	UserID           string        `json:"user_id"`
+	UserName         string        `json:"user_name"`
+	Time             int64         `json:"time"`
+	Message          string        `json:"message"`
+	Segments         []Segment     `json:"segments,omitempty"`
+	OriginalResponse []string      `json:"original_response"`
+	OriginalSegments []RichMessage `json:"original_segments"`
 }
 
+// Wrapper 只返回 Response 时，原回复中的图片与文件会附加在包装后的文字之后；
+// 返回 Segments 时直接使用 Segments
 type PostWrapperResponse struct {
-	Response []string `json:"response"`
+	Response []string      `json:"response"`
+	Segments []RichMessage `json:"segments,omitempty"`
 }
 
 type MessageSendRequest struct {
-	MessageID string   `json:"message_id"`
-	Agent     string   `json:"agent"`
-	GroupID   string   `json:"group_id"`
-	UserID    string   `json:"user_id"`
-	Message   []string `json:"message"`
+	MessageID string        `json:"message_id"`
+	Agent     string        `json:"agent"`
+	GroupID   string        `json:"group_id"`
+	UserID    string        `json:"user_id"`
+	Message   []RichMessage `json:"message"`
+	ReplyMode string        `json:"reply_mode,omitempty"`
+	PluginID  string        `json:"plugin_id,omitempty"`
 }
@@ -0,0 +1,219 @@
+package model
+
+import (
+	"carrota-plugin-center/utils/logs"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	QueuedMessagePending    = "pending"
+	QueuedMessageProcessing = "processing"
+	QueuedMessageDone       = "done"
+	QueuedMessageFailed     = "failed"
+)
+
+// QueuedMessagePayload 为排队消息的原文
+type QueuedMessagePayload MessageInfo
+
+func (p *QueuedMessagePayload) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	case []byte:
+		return json.Unmarshal(v, p)
+	default:
+		return fmt.Errorf("unsupported type: %T", value)
+	}
+}
+
+func (p QueuedMessagePayload) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
+
+// QueuedMessage 为 /message 接收到、等待处理的消息
+type QueuedMessage struct {
+	ID           uint                 `json:"id"            gorm:"primaryKey"`
+	CreatedAt    time.Time            `json:"created_at"`
+	UpdatedAt    time.Time            `json:"updated_at"`
+	Agent        string               `json:"agent"         gorm:"not null"`
+	MessageID    string               `json:"message_id"    gorm:"not null;index"`
+	Status       string               `json:"status"        gorm:"not null;index"`
+	Attempts     int                  `json:"attempts"      gorm:"not null;default:0"`
+	Error        string               `json:"error"         gorm:"not null;default:''"`
+	StartedAt    *time.Time           `json:"started_at"`
+	ClaimedUntil *time.Time           `json:"claimed_until"` // 处理中的消息在该时间后仍未完成时可被其他 worker 重新处理
+	FinishedAt   *time.Time           `json:"finished_at"`
+	Payload      QueuedMessagePayload `json:"payload"       gorm:"type:jsonb;not null"`
+}
+
+func (q QueuedMessage) Message() MessageInfo {
+	return MessageInfo(q.Payload)
+}
+
+func CreateQueuedMessage(message MessageInfo) (QueuedMessage, error) {
+	m := GetModel()
+	defer m.Close()
+
+	queued := QueuedMessage{
+		Agent:     message.Agent,
+		MessageID: message.MessageID,
+		Status:    QueuedMessagePending,
+		Payload:   QueuedMessagePayload(message),
+	}
+	result := m.tx.Create(&queued)
+	if result.Error != nil {
+		logs.Warn("Create queued message failed.", zap.String("agent", message.Agent), zap.String("message_id", message.MessageID), zap.Error(result.Error))
+		m.Abort()
+		return queued, result.Error
+	}
+
+	m.tx.Commit()
+	return queued, nil
+}
+
+// ClaimQueuedMessage 取出最早的一条待处理消息，或租约已过期的处理中消息，标记为处理中并持有 lease 时长的租约，
+// 没有可处理的消息时返回 nil。租约过期（或升级前遗留、没有租约）说明处理该消息的实例已退出或失去响应，
+// 已被中断 maxAttempts 次的消息标记为失败，避免反复导致崩溃的消息无限重试
+func ClaimQueuedMessage(lease time.Duration, maxAttempts int) (*QueuedMessage, error) {
+	m := GetModel()
+	defer m.Close()
+
+	now := time.Now()
+	result := m.tx.Model(&QueuedMessage{}).
+		Where("status = ? AND (claimed_until IS NULL OR claimed_until <= ?) AND attempts >= ?", QueuedMessageProcessing, now, maxAttempts).
+		Updates(map[string]interface{}{
+			"status":        QueuedMessageFailed,
+			"error":         fmt.Sprintf("interrupted %d times", maxAttempts),
+			"claimed_until": nil,
+			"finished_at":   now,
+		})
+	if result.Error != nil {
+		logs.Warn("Fail interrupted messages failed.", zap.Error(result.Error))
+		m.Abort()
+		return nil, result.Error
+	}
+	if result.RowsAffected > 0 {
+		logs.Warn("Interrupted messages marked as failed.", zap.Int64("count", result.RowsAffected), zap.Int("max_attempts", maxAttempts))
+	}
+
+	queued := QueuedMessage{}
+	result = m.tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ? OR (status = ? AND (claimed_until IS NULL OR claimed_until <= ?))", QueuedMessagePending, QueuedMessageProcessing, now).
+		Order("id").Limit(1).Find(&queued)
+	if result.Error != nil {
+		logs.Warn("Claim queued message failed.", zap.Error(result.Error))
+		m.Abort()
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		m.tx.Commit()
+		return nil, nil
+	}
+
+	claimedUntil := now.Add(lease)
+	result = m.tx.Model(&queued).Updates(map[string]interface{}{
+		"status":        QueuedMessageProcessing,
+		"attempts":      gorm.Expr("attempts + 1"),
+		"started_at":    now,
+		"claimed_until": claimedUntil,
+	})
+	if result.Error != nil {
+		logs.Warn("Claim queued message failed.", zap.Uint("id", queued.ID), zap.Error(result.Error))
+		m.Abort()
+		return nil, result.Error
+	}
+
+	m.tx.Commit()
+	queued.Status = QueuedMessageProcessing
+	queued.Attempts++
+	queued.StartedAt = &now
+	queued.ClaimedUntil = &claimedUntil
+	return &queued, nil
+}
+
+// FinishQueuedMessage 记录消息的处理结果，processErr 不为 nil 时标记为失败并记录原因。
+// 只有仍持有该次租约（attempts 未变化）时才会记录，返回是否记录成功
+func FinishQueuedMessage(id uint, attempts int, processErr error) (bool, error) {
+	m := GetModel()
+	defer m.Close()
+
+	status, reason := QueuedMessageDone, ""
+	if processErr != nil {
+		status, reason = QueuedMessageFailed, processErr.Error()
+	}
+	result := m.tx.Model(&QueuedMessage{}).
+		Where("id = ? AND status = ? AND attempts = ?", id, QueuedMessageProcessing, attempts).
+		Updates(map[string]interface{}{
+			"status":        status,
+			"error":         reason,
+			"claimed_until": nil,
+			"finished_at":   time.Now(),
+		})
+	if result.Error != nil {
+		logs.Warn("Finish queued message failed.", zap.Uint("id", id), zap.Error(result.Error))
+		m.Abort()
+		return false, result.Error
+	}
+
+	m.tx.Commit()
+	return result.RowsAffected > 0, nil
+}
+
+// DeleteDoneQueuedMessages 删除 before 之前处理完成的消息，失败的消息保留以便排查
+func DeleteDoneQueuedMessages(before time.Time) (int64, error) {
+	m := GetModel()
+	defer m.Close()
+
+	result := m.tx.Where("status = ? AND finished_at < ?", QueuedMessageDone, before).Delete(&QueuedMessage{})
+	if result.Error != nil {
+		logs.Warn("Delete done queued messages failed.", zap.Error(result.Error))
+		m.Abort()
+		return 0, result.Error
+	}
+
+	m.tx.Commit()
+	return result.RowsAffected, nil
+}
+
+func FindQueuedMessages(status string, limit int) ([]QueuedMessage, error) {
+	m := GetModel()
+	defer m.Close()
+
+	messages := []QueuedMessage{}
+	tx := m.tx.Order("id DESC").Limit(limit)
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	result := tx.Find(&messages)
+	if result.Error != nil {
+		logs.Info("Find queued messages failed.", zap.Error(result.Error))
+		m.Abort()
+		return nil, result.Error
+	}
+
+	m.tx.Commit()
+	return messages, nil
+}
+
+func FindQueuedMessageById(id uint) (QueuedMessage, error) {
+	m := GetModel()
+	defer m.Close()
+
+	queued := QueuedMessage{}
+	result := m.tx.Where("id = ?", id).First(&queued)
+	if result.Error != nil {
+		logs.Info("Find queued message by id failed.", zap.Uint("id", id), zap.Error(result.Error))
+		m.Abort()
+		return queued, result.Error
+	}
+
+	m.tx.Commit()
+	return queued, nil
+}
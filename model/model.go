@@ -27,7 +27,7 @@ func GetModel() *Model {
 }
 
 func InitModel() error {
-	err := AutoMigrateTable(&Plugin{})
+	err := AutoMigrateTable(&Plugin{}, &PluginRevision{}, &PluginCredential{}, &PluginScopeRule{}, &RegistryEvent{}, &QueuedMessage{}, &IdempotencyRecord{}, &ConversationMessage{}, &Agent{}, &WrapperPolicyRule{}, &OutboxMessage{}, &ScheduledMessage{}, &ScheduleRun{})
 	if err != nil {
 		return err
 	}
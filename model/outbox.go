@@ -0,0 +1,228 @@
+package model
+
+import (
+	"carrota-plugin-center/utils/logs"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	OutboxPending   = "pending"
+	OutboxSending   = "sending"
+	OutboxDelivered = "delivered"
+	OutboxFailed    = "failed"
+)
+
+// OutboxPayload 为待发送的消息
+type OutboxPayload []RichMessage
+
+func (p *OutboxPayload) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	case []byte:
+		return json.Unmarshal(v, p)
+	default:
+		return fmt.Errorf("unsupported type: %T", value)
+	}
+}
+
+func (p OutboxPayload) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
+
+// OutboxMessage 为一次发送到 Agent 的请求，由后台的 dispatcher 投递并记录投递结果
+type OutboxMessage struct {
+	ID             uint           `json:"id"               gorm:"primaryKey"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	Agent          string         `json:"agent"            gorm:"not null"`
+	MessageID      string         `json:"message_id"       gorm:"not null;default:''"`
+	GroupID        string         `json:"group_id"         gorm:"not null;default:''"`
+	UserID         string         `json:"user_id"          gorm:"not null;default:''"`
+	ReplyMode      string         `json:"reply_mode"       gorm:"not null;default:''"`
+	PluginID       string         `json:"plugin_id"        gorm:"not null;default:''"`
+	Message        OutboxPayload  `json:"message"          gorm:"type:jsonb;not null"`
+	Status         string         `json:"status"           gorm:"not null;index:idx_outbox_due,priority:1"`
+	Attempts       int            `json:"attempts"         gorm:"not null;default:0"`
+	NextAttemptAt  time.Time      `json:"next_attempt_at"  gorm:"not null;index:idx_outbox_due,priority:2"`
+	LastAttemptAt  *time.Time     `json:"last_attempt_at"`
+	ClaimedUntil   *time.Time     `json:"claimed_until"` // 发送中的消息在该时间后仍未完成时可被其他 worker 重新投递
+	DeliveredAt    *time.Time     `json:"delivered_at"`
+	Error          string         `json:"error"            gorm:"type:text;not null;default:''"`
+	AgentResponse  string         `json:"agent_response"   gorm:"type:text;not null;default:''"` // Agent 最近一次的响应
+	SentMessageIDs pq.StringArray `json:"sent_message_ids" gorm:"type:text[]"`                   // Agent 返回的机器人消息 ID，插件只能撤回以其名义发送的消息
+}
+
+// Origin 返回发送的目标会话
+func (o OutboxMessage) Origin() MessageInfo {
+	return MessageInfo{
+		MessageID: o.MessageID,
+		Agent:     o.Agent,
+		GroupID:   o.GroupID,
+		UserID:    o.UserID,
+	}
+}
+
+// NewOutboxMessage 返回一条在 sendAt 之后投递的待发送消息，尚未写入数据库
+func NewOutboxMessage(target MessageInfo, message []RichMessage, replyMode string, pluginID string, sendAt time.Time) OutboxMessage {
+	return OutboxMessage{
+		Agent:         target.Agent,
+		MessageID:     target.MessageID,
+		GroupID:       target.GroupID,
+		UserID:        target.UserID,
+		ReplyMode:     replyMode,
+		PluginID:      pluginID,
+		Message:       OutboxPayload(message),
+		Status:        OutboxPending,
+		NextAttemptAt: sendAt,
+	}
+}
+
+// CreateOutboxMessage 写入一条待发送的消息
+func CreateOutboxMessage(outbox OutboxMessage) (OutboxMessage, error) {
+	m := GetModel()
+	defer m.Close()
+
+	result := m.tx.Create(&outbox)
+	if result.Error != nil {
+		logs.Warn("Create outbox message failed.", zap.String("agent", outbox.Agent), zap.Error(result.Error))
+		m.Abort()
+		return outbox, result.Error
+	}
+
+	m.tx.Commit()
+	return outbox, nil
+}
+
+// ClaimOutboxMessage 取出最早到期的一条待发送消息，或租约已过期的发送中消息，标记为发送中并持有 lease 时长的租约。
+// 没有可投递的消息时返回 nil
+func ClaimOutboxMessage(lease time.Duration) (*OutboxMessage, error) {
+	m := GetModel()
+	defer m.Close()
+
+	outbox := OutboxMessage{}
+	now := time.Now()
+	result := m.tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("(status = ? AND next_attempt_at <= ?) OR (status = ? AND claimed_until <= ?)", OutboxPending, now, OutboxSending, now).
+		Order("next_attempt_at, id").Limit(1).Find(&outbox)
+	if result.Error != nil {
+		logs.Warn("Claim outbox message failed.", zap.Error(result.Error))
+		m.Abort()
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		m.tx.Commit()
+		return nil, nil
+	}
+	if outbox.Status == OutboxSending {
+		// 持有租约的 worker 已退出或超时，这条消息可能已经送达，Agent 需按 Idempotency-Key 请求头去重
+		logs.Info("Reclaim outbox message with expired lease.", zap.Uint("id", outbox.ID), zap.Int("attempts", outbox.Attempts))
+	}
+
+	claimedUntil := now.Add(lease)
+	result = m.tx.Model(&outbox).Updates(map[string]interface{}{
+		"status":          OutboxSending,
+		"attempts":        gorm.Expr("attempts + 1"),
+		"last_attempt_at": now,
+		"claimed_until":   claimedUntil,
+	})
+	if result.Error != nil {
+		logs.Warn("Claim outbox message failed.", zap.Uint("id", outbox.ID), zap.Error(result.Error))
+		m.Abort()
+		return nil, result.Error
+	}
+
+	m.tx.Commit()
+	outbox.Status = OutboxSending
+	outbox.Attempts++
+	outbox.LastAttemptAt = &now
+	outbox.ClaimedUntil = &claimedUntil
+	return &outbox, nil
+}
+
+// FinishOutboxMessage 记录一次投递的结果。deliverErr 为 nil 时标记为已送达；
+// 否则 retryAt 不为 nil 时在该时间重新投递，为 nil 时标记为失败。
+// attempts 为取出时的投递次数，租约过期后消息已被重新取出时不会覆盖新的投递，返回 false
+func FinishOutboxMessage(id uint, attempts int, agentResponse string, deliverErr error, retryAt *time.Time) (bool, error) {
+	m := GetModel()
+	defer m.Close()
+
+	updates := map[string]interface{}{
+		"agent_response": agentResponse,
+		"error":          "",
+		"claimed_until":  nil,
+	}
+	switch {
+	case deliverErr == nil:
+		updates["status"] = OutboxDelivered
+		updates["delivered_at"] = time.Now()
+		// Agent 可以不返回消息 ID
+		sent := AgentSendResponse{}
+		json.Unmarshal([]byte(agentResponse), &sent)
+		updates["sent_message_ids"] = pq.StringArray(sent.MessageID)
+	case retryAt != nil:
+		updates["status"] = OutboxPending
+		updates["error"] = deliverErr.Error()
+		updates["next_attempt_at"] = *retryAt
+	default:
+		updates["status"] = OutboxFailed
+		updates["error"] = deliverErr.Error()
+	}
+	result := m.tx.Model(&OutboxMessage{}).Where("id = ? AND status = ? AND attempts = ?", id, OutboxSending, attempts).Updates(updates)
+	if result.Error != nil {
+		logs.Warn("Finish outbox message failed.", zap.Uint("id", id), zap.Error(result.Error))
+		m.Abort()
+		return false, result.Error
+	}
+
+	m.tx.Commit()
+	return result.RowsAffected > 0, nil
+}
+
+// IsPluginSentMessage 返回 messageID 是否为以该插件名义发送到 scope 会话的机器人消息
+func IsPluginSentMessage(pluginID string, scope ChatScope, messageID string) (bool, error) {
+	m := GetModel()
+	defer m.Close()
+
+	query := m.tx.Model(&OutboxMessage{}).
+		Where("plugin_id = ? AND agent = ? AND status = ? AND ? = ANY(sent_message_ids)", pluginID, scope.Agent, OutboxDelivered, messageID)
+	if scope.ChatType == ChatTypeGroup {
+		query = query.Where("group_id = ?", scope.ChatID)
+	} else {
+		query = query.Where("group_id = '' AND user_id = ?", scope.ChatID)
+	}
+	var count int64
+	result := query.Count(&count)
+	if result.Error != nil {
+		logs.Info("Find plugin sent message failed.", zap.String("plugin_id", pluginID), zap.Error(result.Error))
+		m.Abort()
+		return false, result.Error
+	}
+
+	m.tx.Commit()
+	return count > 0, nil
+}
+
+func FindOutboxMessageById(id uint) (OutboxMessage, error) {
+	m := GetModel()
+	defer m.Close()
+
+	outbox := OutboxMessage{}
+	result := m.tx.Where("id = ?", id).First(&outbox)
+	if result.Error != nil {
+		logs.Info("Find outbox message by id failed.", zap.Uint("id", id), zap.Error(result.Error))
+		m.Abort()
+		return outbox, result.Error
+	}
+
+	m.tx.Commit()
+	return outbox, nil
+}
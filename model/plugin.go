@@ -4,6 +4,7 @@ import (
 	"carrota-plugin-center/utils/logs"
 	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -39,67 +40,428 @@ func (p PluginParamArray) Value() (driver.Value, error) {
 	return json.Marshal(p)
 }
 
+// PluginRetry 为插件在注册时覆盖的重试策略，未填写的字段使用 Plugin Center 的配置
+type PluginRetry struct {
+	MaxAttempts    int   `json:"max_attempts,omitempty"`
+	InitialBackoff int   `json:"initial_backoff,omitempty"` // 毫秒
+	MaxBackoff     int   `json:"max_backoff,omitempty"`     // 毫秒
+	RetryOnStatus  []int `json:"retry_on_status,omitempty"`
+}
+
+func (p *PluginRetry) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	case []byte:
+		return json.Unmarshal(v, p)
+	default:
+		return fmt.Errorf("unsupported type: %T", value)
+	}
+}
+
+func (p PluginRetry) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
+
+const (
+	PluginStatusActive   = "active"
+	PluginStatusDisabled = "disabled"
+)
+
+const (
+	PluginHealthUnknown   = "unknown"
+	PluginHealthHealthy   = "healthy"
+	PluginHealthUnhealthy = "unhealthy"
+)
+
+var ErrPluginRemoved = errors.New("plugin has been removed")
+
+// PluginSchema 为插件参数的 JSON Schema 原文
+type PluginSchema json.RawMessage
+
+func (p *PluginSchema) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		*p = PluginSchema(v)
+	case []byte:
+		*p = append(PluginSchema(nil), v...)
+	case nil:
+		*p = nil
+	default:
+		return fmt.Errorf("unsupported type: %T", value)
+	}
+	return nil
+}
+
+func (p PluginSchema) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return nil, nil
+	}
+	return string(p), nil
+}
+
+func (p PluginSchema) MarshalJSON() ([]byte, error) {
+	if len(p) == 0 {
+		return []byte("null"), nil
+	}
+	return json.RawMessage(p).MarshalJSON()
+}
+
+func (p *PluginSchema) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*p = nil
+		return nil
+	}
+	*p = append(PluginSchema(nil), b...)
+	return nil
+}
+
 type Plugin struct {
-	ID          string           `json:"id"          form:"id"          query:"id"          gorm:"primaryKey;unique;not null"`
-	CreatedAt   time.Time        `json:"created_at"  form:"created_at"  query:"created_at" `
-	UpdatedAt   time.Time        `json:"updated_at"  form:"updated_at"  query:"updated_at" `
-	DeletedAt   gorm.DeletedAt   `json:"deleted_at"  form:"deleted_at"  query:"deleted_at" `
-	Name        string           `json:"name"        form:"name"        query:"name"        gorm:"not null"`
-	Author      string           `json:"author"      form:"author"      query:"author"      gorm:"not null"`
-	Description string           `json:"description" form:"description" query:"description" gorm:"not null"`
-	Prompt      string           `json:"prompt"      form:"prompt"      query:"prompt"      gorm:"not null"`
-	Params      PluginParamArray `json:"param"       form:"param"       query:"param"       gorm:"type:jsonb"`
-	Format      pq.StringArray   `json:"format"      form:"format"      query:"format"      gorm:"type:text[]"`
-	Example     pq.StringArray   `json:"example"     form:"example"     query:"example"     gorm:"type:text[]"`
-	Url         string           `json:"url"         form:"url"         query:"url"         gorm:"not null"`
+	ID                  string           `json:"id"                   form:"id"                   query:"id"                   gorm:"primaryKey;unique;not null"`
+	CreatedAt           time.Time        `json:"created_at"           form:"created_at"           query:"created_at"          `
+	UpdatedAt           time.Time        `json:"updated_at"           form:"updated_at"           query:"updated_at"          `
+	DeletedAt           gorm.DeletedAt   `json:"deleted_at"           form:"deleted_at"           query:"deleted_at"          `
+	Name                string           `json:"name"                 form:"name"                 query:"name"                 gorm:"not null"`
+	Author              string           `json:"author"               form:"author"               query:"author"               gorm:"not null"`
+	Description         string           `json:"description"          form:"description"          query:"description"          gorm:"not null"`
+	Prompt              string           `json:"prompt"               form:"prompt"               query:"prompt"               gorm:"not null"`
+	Params              PluginParamArray `json:"param"                form:"param"                query:"param"                gorm:"type:jsonb"`
+	Schema              PluginSchema     `json:"schema"               form:"schema"               query:"schema"               gorm:"type:jsonb"`
+	Format              pq.StringArray   `json:"format"               form:"format"               query:"format"               gorm:"type:text[]"`
+	Example             pq.StringArray   `json:"example"              form:"example"              query:"example"              gorm:"type:text[]"`
+	Url                 string           `json:"url"                  form:"url"                  query:"url"                  gorm:"not null"`
+	HealthUrl           string           `json:"health_url"           form:"health_url"           query:"health_url"           gorm:"not null;default:''"`
+	Timeout             int              `json:"timeout"              form:"timeout"              query:"timeout"              gorm:"not null;default:0"`
+	ReplyMode           string           `json:"reply_mode"           form:"reply_mode"           query:"reply_mode"           gorm:"not null;default:reply"`
+	WrapperPolicy       string           `json:"wrapper_policy"       form:"wrapper_policy"       query:"wrapper_policy"       gorm:"not null;default:''"`
+	Retry               *PluginRetry     `json:"retry"                form:"retry"                query:"retry"                gorm:"type:jsonb"`
+	Status              string           `json:"status"               form:"status"               query:"status"               gorm:"not null;default:active"`
+	ConsecutiveFailures int              `json:"consecutive_failures" form:"consecutive_failures" query:"consecutive_failures" gorm:"not null;default:0"`
+	LastSuccessAt       *time.Time       `json:"last_success_at"      form:"last_success_at"      query:"last_success_at"     `
+	LastFailureAt       *time.Time       `json:"last_failure_at"      form:"last_failure_at"      query:"last_failure_at"     `
+	PinnedRevision      int              `json:"pinned_revision"      form:"pinned_revision"      query:"pinned_revision"      gorm:"not null;default:0"`
+	HealthStatus        string           `json:"health_status"        form:"health_status"        query:"health_status"        gorm:"not null;default:unknown"`
+	HealthLatencyMs     int64            `json:"health_latency_ms"    form:"health_latency_ms"    query:"health_latency_ms"    gorm:"not null;default:0"`
+	HealthCheckedAt     *time.Time       `json:"health_checked_at"    form:"health_checked_at"    query:"health_checked_at"   `
+	HealthError         string           `json:"health_error"         form:"health_error"         query:"health_error"         gorm:"not null;default:''"`
 }
 
 type PluginInfo struct {
-	ID          string           `json:"id"          `
-	Name        string           `json:"name"        `
-	Author      string           `json:"author"      `
-	Description string           `json:"description" `
-	Prompt      string           `json:"prompt"      `
-	Params      PluginParamArray `json:"param"       `
-	Format      []string         `json:"format"      `
-	Example     []string         `json:"example"     `
-	Url         string           `json:"url"         `
-}
-
-func CreatePluginRegisterRecord(plugin PluginInfo) error {
+	ID                  string           `json:"id"                             `
+	Name                string           `json:"name"                           `
+	Author              string           `json:"author"                         `
+	Description         string           `json:"description"                    `
+	Prompt              string           `json:"prompt"                         `
+	Params              PluginParamArray `json:"param"                          `
+	Schema              PluginSchema     `json:"schema,omitempty"               `
+	Format              []string         `json:"format"                         `
+	Example             []string         `json:"example"                        `
+	Url                 string           `json:"url"                            `
+	HealthUrl           string           `json:"health_url,omitempty"           `
+	Timeout             int              `json:"timeout,omitempty"              `
+	ReplyMode           string           `json:"reply_mode,omitempty"           `
+	WrapperPolicy       string           `json:"wrapper_policy,omitempty"       `
+	Retry               *PluginRetry     `json:"retry,omitempty"                `
+	Status              string           `json:"status,omitempty"               `
+	ConsecutiveFailures int              `json:"consecutive_failures,omitempty" `
+	LastSuccessAt       *time.Time       `json:"last_success_at,omitempty"      `
+	LastFailureAt       *time.Time       `json:"last_failure_at,omitempty"      `
+	PinnedRevision      int              `json:"pinned_revision,omitempty"      `
+	HealthStatus        string           `json:"health_status,omitempty"        `
+	HealthLatencyMs     int64            `json:"health_latency_ms,omitempty"    `
+	HealthCheckedAt     *time.Time       `json:"health_checked_at,omitempty"    `
+	HealthError         string           `json:"health_error,omitempty"         `
+}
+
+func (p Plugin) Info() PluginInfo {
+	return PluginInfo{
+		ID:                  p.ID,
+		Name:                p.Name,
+		Author:              p.Author,
+		Description:         p.Description,
+		Prompt:              p.Prompt,
+		Params:              p.Params,
+		Schema:              p.Schema,
+		Format:              p.Format,
+		Example:             p.Example,
+		Url:                 p.Url,
+		HealthUrl:           p.HealthUrl,
+		Timeout:             p.Timeout,
+		ReplyMode:           p.ReplyMode,
+		WrapperPolicy:       p.WrapperPolicy,
+		Retry:               p.Retry,
+		Status:              p.Status,
+		ConsecutiveFailures: p.ConsecutiveFailures,
+		LastSuccessAt:       p.LastSuccessAt,
+		LastFailureAt:       p.LastFailureAt,
+		PinnedRevision:      p.PinnedRevision,
+		HealthStatus:        p.HealthStatus,
+		HealthLatencyMs:     p.HealthLatencyMs,
+		HealthCheckedAt:     p.HealthCheckedAt,
+		HealthError:         p.HealthError,
+	}
+}
+
+// 插件注册时由插件提供的字段，修改注册信息相关字段时需同步修改此处
+var pluginRegistrationColumns = []string{
+	"name", "author", "description", "prompt", "params", "schema", "format", "example", "url", "health_url", "timeout", "retry", "reply_mode", "wrapper_policy",
+}
+
+// Registration 返回仅包含插件注册字段的副本，用于保存修订快照
+func (p PluginInfo) Registration() PluginInfo {
+	return PluginInfo{
+		ID:            p.ID,
+		Name:          p.Name,
+		Author:        p.Author,
+		Description:   p.Description,
+		Prompt:        p.Prompt,
+		Params:        p.Params,
+		Schema:        p.Schema,
+		Format:        p.Format,
+		Example:       p.Example,
+		Url:           p.Url,
+		HealthUrl:     p.HealthUrl,
+		Timeout:       p.Timeout,
+		ReplyMode:     p.ReplyMode,
+		WrapperPolicy: p.WrapperPolicy,
+		Retry:         p.Retry,
+	}
+}
+
+func (p PluginInfo) record() Plugin {
+	return Plugin{
+		ID:            p.ID,
+		Name:          p.Name,
+		Author:        p.Author,
+		Description:   p.Description,
+		Prompt:        p.Prompt,
+		Params:        p.Params,
+		Schema:        p.Schema,
+		Format:        pq.StringArray(p.Format),
+		Example:       pq.StringArray(p.Example),
+		Url:           p.Url,
+		HealthUrl:     p.HealthUrl,
+		Timeout:       p.Timeout,
+		ReplyMode:     p.ReplyMode,
+		WrapperPolicy: p.WrapperPolicy,
+		Retry:         p.Retry,
+	}
+}
+
+// 插件被回滚并固定到某一修订时，重新注册只会重置存活状态并记录新修订，不会覆盖注册信息。
+// newTokenID 不为空时在同一事务中为插件绑定凭证，插件已有凭证时返回 ErrPluginCredentialExists
+func CreatePluginRegisterRecord(plugin PluginInfo, registeredBy string, newTokenID string) error {
 	m := GetModel()
 	defer m.Close()
 
-	record := Plugin{
-		ID:          plugin.ID,
-		Name:        plugin.Name,
-		Author:      plugin.Author,
-		Description: plugin.Description,
-		Prompt:      plugin.Prompt,
-		Params:      plugin.Params,
-		Format:      pq.StringArray(plugin.Format),
-		Example:     pq.StringArray(plugin.Example),
-		Url:         plugin.Url,
-	}
-	result := m.tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&record)
+	// 已被管理员删除的插件不能通过重新注册恢复，需调用 restore 接口
+	var removed int64
+	result := m.tx.Unscoped().Model(&Plugin{}).Where("id = ? AND deleted_at IS NOT NULL", plugin.ID).Count(&removed)
+	if result.Error != nil {
+		logs.Warn("Check removed plugin failed.", zap.Error(result.Error))
+		m.Abort()
+		return result.Error
+	}
+	if removed > 0 {
+		m.Abort()
+		return ErrPluginRemoved
+	}
+
+	var existing []Plugin
+	result = m.tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", plugin.ID).Limit(1).Find(&existing)
+	if result.Error != nil {
+		logs.Warn("Find registered plugin failed.", zap.Error(result.Error))
+		m.Abort()
+		return result.Error
+	}
+	pinned := len(existing) > 0 && existing[0].PinnedRevision > 0
+
+	if pinned {
+		result = m.tx.Model(&Plugin{}).Where("id = ?", plugin.ID).Updates(map[string]interface{}{
+			"status":               PluginStatusActive,
+			"consecutive_failures": 0,
+		})
+	} else {
+		record := plugin.record()
+		record.Status = PluginStatusActive
+		record.ConsecutiveFailures = 0
+		// 重新注册会重置存活状态，但保留最近一次成功/失败时间
+		result = m.tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "id"}},
+			DoUpdates: clause.AssignmentColumns(append([]string{"updated_at", "status", "consecutive_failures"}, pluginRegistrationColumns...)),
+		}).Create(&record)
+	}
 	if result.Error != nil {
 		logs.Warn("Create PluginRegisterRecord failed.", zap.Error(result.Error))
 		m.Abort()
 		return result.Error
 	}
 
+	revision, created, err := m.appendPluginRevision(plugin.Registration(), registeredBy, PluginRevisionActionRegister, 0, !pinned)
+	if err != nil {
+		m.Abort()
+		return err
+	}
+
+	// 只有注册信息实际发生变化或插件状态改变时才记录注册表事件
+	var events []string
+	switch {
+	case len(existing) == 0:
+		events = append(events, RegistryEventAdded)
+	case created && !pinned:
+		events = append(events, RegistryEventUpdated)
+	}
+	if len(existing) > 0 && existing[0].Status == PluginStatusDisabled {
+		events = append(events, RegistryEventEnabled)
+	}
+	for _, event := range events {
+		err = m.recordRegistryEvent(plugin.ID, event, revision.Revision)
+		if err != nil {
+			m.Abort()
+			return err
+		}
+	}
+
+	if newTokenID != "" {
+		err = m.createPluginCredential(plugin.ID, newTokenID)
+		if err != nil {
+			m.Abort()
+			return err
+		}
+	}
+
 	m.tx.Commit()
 	return nil
 }
 
-func FindPluginList() ([]PluginInfo, error) {
+func FindPluginById(id string) (PluginInfo, error) {
+	m := GetModel()
+	defer m.Close()
+
+	var plugin Plugin
+	result := m.tx.Model(&Plugin{}).Where("id = ?", id).First(&plugin)
+	if result.Error != nil {
+		logs.Info("Find plugin by id failed.", zap.Error(result.Error))
+		m.Abort()
+		return PluginInfo{}, result.Error
+	}
+
+	m.tx.Commit()
+	return plugin.Info(), nil
+}
+
+func DeletePluginById(id string) error {
+	m := GetModel()
+	defer m.Close()
+
+	result := m.tx.Where("id = ?", id).Delete(&Plugin{})
+	if result.Error != nil {
+		logs.Info("Delete plugin by id failed.", zap.Error(result.Error))
+		m.Abort()
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		m.Abort()
+		return gorm.ErrRecordNotFound
+	}
+	err := m.recordRegistryEvent(id, RegistryEventRemoved, 0)
+	if err != nil {
+		m.Abort()
+		return err
+	}
+
+	m.tx.Commit()
+	return nil
+}
+
+func RestorePluginById(id string) error {
+	m := GetModel()
+	defer m.Close()
+
+	result := m.tx.Unscoped().Model(&Plugin{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		UpdateColumn("deleted_at", nil)
+	if result.Error != nil {
+		logs.Info("Restore plugin by id failed.", zap.Error(result.Error))
+		m.Abort()
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		m.Abort()
+		return gorm.ErrRecordNotFound
+	}
+	err := m.recordRegistryEvent(id, RegistryEventAdded, 0)
+	if err != nil {
+		m.Abort()
+		return err
+	}
+
+	m.tx.Commit()
+	return nil
+}
+
+func RecordPluginDeliverySuccess(id string) error {
+	m := GetModel()
+	defer m.Close()
+
+	result := m.tx.Model(&Plugin{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"consecutive_failures": 0,
+		"last_success_at":      time.Now(),
+	})
+	if result.Error != nil {
+		logs.Warn("Record plugin delivery success failed.", zap.String("id", id), zap.Error(result.Error))
+		m.Abort()
+		return result.Error
+	}
+
+	m.tx.Commit()
+	return nil
+}
+
+// 连续投递失败达到 maxFailures 次后将插件标记为 disabled，返回插件是否因此被停用
+func RecordPluginDeliveryFailure(id string, maxFailures int) (bool, error) {
+	m := GetModel()
+	defer m.Close()
+
+	result := m.tx.Model(&Plugin{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"consecutive_failures": gorm.Expr("consecutive_failures + 1"),
+		"last_failure_at":      time.Now(),
+	})
+	if result.Error != nil {
+		logs.Warn("Record plugin delivery failure failed.", zap.String("id", id), zap.Error(result.Error))
+		m.Abort()
+		return false, result.Error
+	}
+
+	result = m.tx.Model(&Plugin{}).
+		Where("id = ? AND status = ? AND consecutive_failures >= ?", id, PluginStatusActive, maxFailures).
+		UpdateColumn("status", PluginStatusDisabled)
+	if result.Error != nil {
+		logs.Warn("Disable plugin failed.", zap.String("id", id), zap.Error(result.Error))
+		m.Abort()
+		return false, result.Error
+	}
+	disabled := result.RowsAffected > 0
+	if disabled {
+		err := m.recordRegistryEvent(id, RegistryEventDisabled, 0)
+		if err != nil {
+			m.Abort()
+			return false, err
+		}
+	}
+
+	m.tx.Commit()
+	return disabled, nil
+}
+
+func FindPluginsWithHealthUrl() ([]PluginInfo, error) {
 	m := GetModel()
 	defer m.Close()
 
 	var plugins []Plugin
-	result := m.tx.Model(&Plugin{}).Find(&plugins)
+	result := m.tx.Where("health_url <> ''").Find(&plugins)
 	if result.Error != nil {
-		logs.Info("Find plugin list failed.", zap.Error(result.Error))
+		logs.Info("Find plugins with health url failed.", zap.Error(result.Error))
 		m.Abort()
 		return nil, result.Error
 	}
@@ -107,54 +469,40 @@ func FindPluginList() ([]PluginInfo, error) {
 	m.tx.Commit()
 	var pluginInfos []PluginInfo
 	for _, plugin := range plugins {
-		pluginInfos = append(pluginInfos, PluginInfo{
-			ID:          plugin.ID,
-			Name:        plugin.Name,
-			Author:      plugin.Author,
-			Description: plugin.Description,
-			Prompt:      plugin.Prompt,
-			Params:      plugin.Params,
-			Format:      plugin.Format,
-			Example:     plugin.Example,
-			Url:         plugin.Url,
-		})
+		pluginInfos = append(pluginInfos, plugin.Info())
 	}
 	return pluginInfos, nil
 }
 
-func FindPluginById(id string) (PluginInfo, error) {
+// 所有未删除插件的地址（去重）
+func FindPluginUrls() ([]string, error) {
 	m := GetModel()
 	defer m.Close()
 
-	var plugin Plugin
-	result := m.tx.Model(&Plugin{}).Where("id = ?", id).First(&plugin)
+	var urls []string
+	result := m.tx.Model(&Plugin{}).Distinct("url").Pluck("url", &urls)
 	if result.Error != nil {
-		logs.Info("Find plugin by id failed.", zap.Error(result.Error))
+		logs.Info("Find plugin urls failed.", zap.Error(result.Error))
 		m.Abort()
-		return PluginInfo{}, result.Error
+		return nil, result.Error
 	}
 
 	m.tx.Commit()
-	return PluginInfo{
-		ID:          plugin.ID,
-		Name:        plugin.Name,
-		Author:      plugin.Author,
-		Description: plugin.Description,
-		Prompt:      plugin.Prompt,
-		Params:      plugin.Params,
-		Format:      plugin.Format,
-		Example:     plugin.Example,
-		Url:         plugin.Url,
-	}, nil
+	return urls, nil
 }
 
-func DeletePluginById(id string) error {
+func RecordPluginHealth(id string, status string, latency time.Duration, healthErr string) error {
 	m := GetModel()
 	defer m.Close()
 
-	result := m.tx.Where("id = ?", id).Delete(&Plugin{})
+	result := m.tx.Model(&Plugin{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"health_status":     status,
+		"health_latency_ms": latency.Milliseconds(),
+		"health_checked_at": time.Now(),
+		"health_error":      healthErr,
+	})
 	if result.Error != nil {
-		logs.Info("Delete plugin by id failed.", zap.Error(result.Error))
+		logs.Warn("Record plugin health failed.", zap.String("id", id), zap.Error(result.Error))
 		m.Abort()
 		return result.Error
 	}
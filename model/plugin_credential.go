@@ -0,0 +1,73 @@
+package model
+
+import (
+	"carrota-plugin-center/utils/logs"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm/clause"
+)
+
+var ErrPluginCredentialExists = errors.New("plugin credential already issued")
+
+// PluginCredential 绑定插件 ID 与其凭证中的随机 ID
+type PluginCredential struct {
+	PluginID  string    `json:"plugin_id"  gorm:"primaryKey;not null"`
+	TokenID   string    `json:"-"          gorm:"not null"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func FindPluginCredential(pluginID string) (PluginCredential, error) {
+	m := GetModel()
+	defer m.Close()
+
+	var credential PluginCredential
+	result := m.tx.Where("plugin_id = ?", pluginID).First(&credential)
+	if result.Error != nil {
+		logs.Debug("Find plugin credential failed.", zap.String("id", pluginID), zap.Error(result.Error))
+		m.Abort()
+		return PluginCredential{}, result.Error
+	}
+
+	m.tx.Commit()
+	return credential, nil
+}
+
+// 仅在插件尚无凭证时写入，已存在时返回 ErrPluginCredentialExists
+func (m *Model) createPluginCredential(pluginID string, tokenID string) error {
+	result := m.tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&PluginCredential{
+		PluginID: pluginID,
+		TokenID:  tokenID,
+	})
+	if result.Error != nil {
+		logs.Warn("Create plugin credential failed.", zap.String("id", pluginID), zap.Error(result.Error))
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPluginCredentialExists
+	}
+	return nil
+}
+
+func ResetPluginCredential(pluginID string, tokenID string) error {
+	m := GetModel()
+	defer m.Close()
+
+	result := m.tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "plugin_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"token_id", "updated_at"}),
+	}).Create(&PluginCredential{
+		PluginID: pluginID,
+		TokenID:  tokenID,
+	})
+	if result.Error != nil {
+		logs.Warn("Reset plugin credential failed.", zap.String("id", pluginID), zap.Error(result.Error))
+		m.Abort()
+		return result.Error
+	}
+
+	m.tx.Commit()
+	return nil
+}
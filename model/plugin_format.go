@@ -0,0 +1,73 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+)
+
+// 由插件 format 编译得到的匹配模板，${key} 匹配任意非空文字，其余部分按字面匹配
+type formatTemplate struct {
+	pattern *regexp.Regexp
+	keys    []string
+	literal int // 字面文字的长度，越长的模板越具体
+}
+
+// 消息与模板首尾的空白与标点不参与匹配，如 "语文作业什么时候交？" 可以匹配 "${subject}作业什么时候交"
+const formatTrimChars = " \t\r\n?？!！。.,，~～"
+
+// 模板在每次匹配时编译，format 很短，不缓存以免插件重新注册后旧模板一直留在内存中
+func compileFormat(format string) *formatTemplate {
+	trimmed := strings.Trim(format, formatTrimChars)
+	t := &formatTemplate{}
+	b := strings.Builder{}
+	// 占位符可以匹配包含换行的文字
+	b.WriteString("(?s)^")
+	last := 0
+	for _, loc := range formatPlaceholder.FindAllStringSubmatchIndex(trimmed, -1) {
+		literal := trimmed[last:loc[0]]
+		t.literal += len([]rune(literal))
+		b.WriteString(regexp.QuoteMeta(literal))
+		b.WriteString("(.+?)")
+		t.keys = append(t.keys, trimmed[loc[2]:loc[3]])
+		last = loc[1]
+	}
+	t.literal += len([]rune(trimmed[last:]))
+	b.WriteString(regexp.QuoteMeta(trimmed[last:]))
+	b.WriteString("$")
+	t.pattern = regexp.MustCompile(b.String())
+	return t
+}
+
+// MatchFormat 按插件注册的 format 匹配消息，返回以参数 key 为键的参数。
+// 多个 format 同时匹配时使用字面文字最长（最具体）的一个
+func (p PluginInfo) MatchFormat(message string) (map[string]interface{}, bool) {
+	message = strings.Trim(message, formatTrimChars)
+	var best *formatTemplate
+	var bestMatch []string
+	for _, format := range p.Format {
+		t := compileFormat(format)
+		match := t.pattern.FindStringSubmatch(message)
+		if match == nil || (best != nil && t.literal <= best.literal) {
+			continue
+		}
+		valid := true
+		for _, value := range match[1:] {
+			if strings.TrimSpace(value) == "" {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			best, bestMatch = t, match
+		}
+	}
+	if best == nil {
+		return nil, false
+	}
+
+	param := map[string]interface{}{}
+	for i, key := range best.keys {
+		param[key] = strings.TrimSpace(bestMatch[i+1])
+	}
+	return param, true
+}
@@ -0,0 +1,101 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  []string
+		message string
+		param   map[string]interface{}
+		ok      bool
+	}{
+		{
+			name:    "single placeholder",
+			format:  []string{"${subject}作业什么时候交"},
+			message: "语文作业什么时候交",
+			param:   map[string]interface{}{"subject": "语文"},
+			ok:      true,
+		},
+		{
+			name:    "trailing punctuation is ignored",
+			format:  []string{"${subject}作业什么时候交？"},
+			message: "  语文作业什么时候交!!",
+			param:   map[string]interface{}{"subject": "语文"},
+			ok:      true,
+		},
+		{
+			name:    "multiple placeholders",
+			format:  []string{"把${from}翻译成${to}"},
+			message: "把你好翻译成英文",
+			param:   map[string]interface{}{"from": "你好", "to": "英文"},
+			ok:      true,
+		},
+		{
+			name:    "placeholder value is trimmed",
+			format:  []string{"查询 ${city} 天气"},
+			message: "查询  北京  天气",
+			param:   map[string]interface{}{"city": "北京"},
+			ok:      true,
+		},
+		{
+			name:    "placeholder matches newlines",
+			format:  []string{"翻译${text}"},
+			message: "翻译第一行\n第二行",
+			param:   map[string]interface{}{"text": "第一行\n第二行"},
+			ok:      true,
+		},
+		{
+			name:    "literal text is not a pattern",
+			format:  []string{"${a}.*${b}"},
+			message: "x.*y",
+			param:   map[string]interface{}{"a": "x", "b": "y"},
+			ok:      true,
+		},
+		{
+			name:    "literal does not match",
+			format:  []string{"${subject}作业什么时候交"},
+			message: "语文考试什么时候",
+			ok:      false,
+		},
+		{
+			name:    "empty placeholder does not match",
+			format:  []string{"${subject}作业什么时候交"},
+			message: "作业什么时候交",
+			ok:      false,
+		},
+		{
+			name:    "blank placeholder does not match",
+			format:  []string{"查询${city}天气"},
+			message: "查询   天气",
+			ok:      false,
+		},
+		{
+			name:    "longest literal wins",
+			format:  []string{"${q}", "${subject}作业什么时候交"},
+			message: "语文作业什么时候交",
+			param:   map[string]interface{}{"subject": "语文"},
+			ok:      true,
+		},
+		{
+			name:    "no format",
+			message: "语文作业什么时候交",
+			ok:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			param, ok := PluginInfo{Format: tt.format}.MatchFormat(tt.message)
+			if ok != tt.ok {
+				t.Fatalf("MatchFormat(%q) ok = %v, want %v", tt.message, ok, tt.ok)
+			}
+			if ok && !reflect.DeepEqual(param, tt.param) {
+				t.Errorf("MatchFormat(%q) = %v, want %v", tt.message, param, tt.param)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package model
+
+import (
+	"carrota-plugin-center/utils/logs"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	PluginListStatusAll = "all"
+	PluginListMaxLimit  = 100
+)
+
+var pluginListSortColumns = map[string]bool{
+	"id":         true,
+	"name":       true,
+	"author":     true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+var ErrInvalidPluginListFilter = errors.New("invalid plugin list filter")
+
+type PluginListFilter struct {
+	Author       string
+	IDPrefix     string
+	Search       string
+	Status       string // 为空时只返回 active 插件，为 all 时返回全部
+	Health       string
+	UpdatedSince *time.Time
+	Scope        *ChatScope // 不为空时只返回在该会话中可用的插件
+	Sort         string     // 排序字段，前缀 - 表示降序，默认按 id 升序
+	Cursor       string
+	Limit        int // 为 0 时不分页
+}
+
+type PluginListResult struct {
+	Total      int64        `json:"total"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+	Plugins    []PluginInfo `json:"plugins"`
+}
+
+type pluginListCursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+func (f PluginListFilter) sortColumn() (column string, desc bool, err error) {
+	column = strings.TrimPrefix(f.Sort, "-")
+	desc = strings.HasPrefix(f.Sort, "-")
+	if column == "" {
+		column = "id"
+	}
+	if !pluginListSortColumns[column] {
+		return "", false, ErrInvalidPluginListFilter
+	}
+	return column, desc, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (f PluginListFilter) apply(tx *gorm.DB) *gorm.DB {
+	switch f.Status {
+	case "":
+		tx = tx.Where("status = ?", PluginStatusActive)
+	case PluginListStatusAll:
+	default:
+		tx = tx.Where("status = ?", f.Status)
+	}
+	if f.Health != "" {
+		tx = tx.Where("health_status = ?", f.Health)
+	}
+	if f.Author != "" {
+		tx = tx.Where("author = ?", f.Author)
+	}
+	if f.IDPrefix != "" {
+		tx = tx.Where("id LIKE ?", escapeLike(f.IDPrefix)+"%")
+	}
+	if f.Search != "" {
+		pattern := "%" + escapeLike(f.Search) + "%"
+		tx = tx.Where("name ILIKE ? OR description ILIKE ? OR prompt ILIKE ?", pattern, pattern, pattern)
+	}
+	if f.UpdatedSince != nil {
+		tx = tx.Where("updated_at >= ?", *f.UpdatedSince)
+	}
+	if f.Scope != nil {
+		tx = pluginEnabledInScope(tx, *f.Scope)
+	}
+	return tx
+}
+
+func cursorValue(plugin Plugin, column string) string {
+	switch column {
+	case "name":
+		return plugin.Name
+	case "author":
+		return plugin.Author
+	case "created_at":
+		return plugin.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		return plugin.UpdatedAt.Format(time.RFC3339Nano)
+	}
+	return plugin.ID
+}
+
+func encodePluginListCursor(plugin Plugin, column string) string {
+	b, _ := json.Marshal(pluginListCursor{Value: cursorValue(plugin, column), ID: plugin.ID})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func applyPluginListCursor(tx *gorm.DB, cursor string, column string, desc bool) (*gorm.DB, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidPluginListFilter
+	}
+	c := pluginListCursor{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, ErrInvalidPluginListFilter
+	}
+
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	if column == "id" {
+		return tx.Where("id "+op+" ?", c.ID), nil
+	}
+
+	var value interface{} = c.Value
+	if column == "created_at" || column == "updated_at" {
+		t, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return nil, ErrInvalidPluginListFilter
+		}
+		value = t
+	}
+	// 排序字段相同时以 id 作为第二排序键，保证游标稳定
+	return tx.Where("("+column+" "+op+" ?) OR ("+column+" = ? AND id "+op+" ?)", value, value, c.ID), nil
+}
+
+func FindPluginList(filter PluginListFilter) (PluginListResult, error) {
+	column, desc, err := filter.sortColumn()
+	if err != nil {
+		return PluginListResult{}, err
+	}
+	if filter.Limit < 0 || filter.Limit > PluginListMaxLimit {
+		return PluginListResult{}, ErrInvalidPluginListFilter
+	}
+
+	m := GetModel()
+	defer m.Close()
+
+	var total int64
+	result := filter.apply(m.tx.Model(&Plugin{})).Count(&total)
+	if result.Error != nil {
+		logs.Info("Count plugin list failed.", zap.Error(result.Error))
+		m.Abort()
+		return PluginListResult{}, result.Error
+	}
+
+	tx := filter.apply(m.tx.Model(&Plugin{}))
+	if filter.Cursor != "" {
+		tx, err = applyPluginListCursor(tx, filter.Cursor, column, desc)
+		if err != nil {
+			m.Abort()
+			return PluginListResult{}, err
+		}
+	}
+	order := " ASC"
+	if desc {
+		order = " DESC"
+	}
+	tx = tx.Order(column + order)
+	if column != "id" {
+		tx = tx.Order("id" + order)
+	}
+	if filter.Limit > 0 {
+		tx = tx.Limit(filter.Limit + 1)
+	}
+
+	var plugins []Plugin
+	result = tx.Find(&plugins)
+	if result.Error != nil {
+		logs.Info("Find plugin list failed.", zap.Error(result.Error))
+		m.Abort()
+		return PluginListResult{}, result.Error
+	}
+
+	m.tx.Commit()
+	list := PluginListResult{
+		Total:   total,
+		Plugins: []PluginInfo{},
+	}
+	if filter.Limit > 0 && len(plugins) > filter.Limit {
+		plugins = plugins[:filter.Limit]
+		list.NextCursor = encodePluginListCursor(plugins[len(plugins)-1], column)
+	}
+	for _, plugin := range plugins {
+		list.Plugins = append(list.Plugins, plugin.Info())
+	}
+	return list, nil
+}
@@ -0,0 +1,204 @@
+package model
+
+import (
+	"carrota-plugin-center/utils/logs"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	PluginRevisionActionRegister = "register"
+	PluginRevisionActionRollback = "rollback"
+)
+
+type PluginSnapshot PluginInfo
+
+func (p *PluginSnapshot) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	case []byte:
+		return json.Unmarshal(v, p)
+	default:
+		return fmt.Errorf("unsupported type: %T", value)
+	}
+}
+
+func (p PluginSnapshot) Value() (driver.Value, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (p PluginSnapshot) hash() string {
+	b, _ := json.Marshal(p)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// PluginRevision 只追加不修改，记录插件每一次内容发生变化的注册信息
+type PluginRevision struct {
+	ID           uint           `json:"-"             gorm:"primaryKey"`
+	PluginID     string         `json:"plugin_id"     gorm:"not null;uniqueIndex:idx_plugin_revision"`
+	Revision     int            `json:"revision"      gorm:"not null;uniqueIndex:idx_plugin_revision"`
+	CreatedAt    time.Time      `json:"created_at"   `
+	RegisteredBy string         `json:"registered_by" gorm:"not null"`
+	Action       string         `json:"action"        gorm:"not null"`
+	RollbackOf   int            `json:"rollback_of,omitempty"`
+	Applied      bool           `json:"applied"       gorm:"not null"` // 插件被固定时收到的注册不会生效
+	Hash         string         `json:"hash"          gorm:"not null"`
+	Snapshot     PluginSnapshot `json:"snapshot"      gorm:"type:jsonb;not null"`
+}
+
+// 内容与最新修订相同的注册不追加，返回最新修订，created 为 false
+func (m *Model) appendPluginRevision(plugin PluginInfo, registeredBy string, action string, rollbackOf int, applied bool) (revision PluginRevision, created bool, err error) {
+	snapshot := PluginSnapshot(plugin)
+	hash := snapshot.hash()
+
+	var latest []PluginRevision
+	result := m.tx.Where("plugin_id = ?", plugin.ID).Order("revision DESC").Limit(1).Find(&latest)
+	if result.Error != nil {
+		logs.Warn("Find latest plugin revision failed.", zap.String("id", plugin.ID), zap.Error(result.Error))
+		return PluginRevision{}, false, result.Error
+	}
+	next := 1
+	if len(latest) > 0 {
+		if latest[0].Hash == hash && action == PluginRevisionActionRegister {
+			return latest[0], false, nil
+		}
+		next = latest[0].Revision + 1
+	}
+
+	revision = PluginRevision{
+		PluginID:     plugin.ID,
+		Revision:     next,
+		RegisteredBy: registeredBy,
+		Action:       action,
+		RollbackOf:   rollbackOf,
+		Applied:      applied,
+		Hash:         hash,
+		Snapshot:     snapshot,
+	}
+	result = m.tx.Create(&revision)
+	if result.Error != nil {
+		logs.Warn("Create plugin revision failed.", zap.String("id", plugin.ID), zap.Error(result.Error))
+		return PluginRevision{}, false, result.Error
+	}
+	logs.Info("Plugin revision created.", zap.String("id", plugin.ID), zap.Int("revision", next), zap.String("action", action), zap.String("registeredBy", registeredBy))
+	return revision, true, nil
+}
+
+func FindPluginRevisions(id string) ([]PluginRevision, error) {
+	m := GetModel()
+	defer m.Close()
+
+	revisions := []PluginRevision{}
+	result := m.tx.Where("plugin_id = ?", id).Order("revision DESC").Find(&revisions)
+	if result.Error != nil {
+		logs.Info("Find plugin revisions failed.", zap.Error(result.Error))
+		m.Abort()
+		return nil, result.Error
+	}
+
+	m.tx.Commit()
+	return revisions, nil
+}
+
+func FindPluginRevision(id string, revision int) (PluginRevision, error) {
+	m := GetModel()
+	defer m.Close()
+
+	var r PluginRevision
+	result := m.tx.Where("plugin_id = ? AND revision = ?", id, revision).First(&r)
+	if result.Error != nil {
+		logs.Info("Find plugin revision failed.", zap.Error(result.Error))
+		m.Abort()
+		return PluginRevision{}, result.Error
+	}
+
+	m.tx.Commit()
+	return r, nil
+}
+
+// RollbackPlugin 将插件注册信息恢复为指定修订并固定，之后插件重新注册不会覆盖，直到调用 UnpinPlugin
+func RollbackPlugin(id string, revision int, registeredBy string) (PluginInfo, error) {
+	m := GetModel()
+	defer m.Close()
+
+	var target PluginRevision
+	result := m.tx.Where("plugin_id = ? AND revision = ?", id, revision).First(&target)
+	if result.Error != nil {
+		logs.Info("Find plugin revision failed.", zap.Error(result.Error))
+		m.Abort()
+		return PluginInfo{}, result.Error
+	}
+
+	record := PluginInfo(target.Snapshot).record()
+	result = m.tx.Model(&Plugin{}).Where("id = ?", id).Select(append([]string{"updated_at"}, pluginRegistrationColumns...)).Updates(&record)
+	if result.Error != nil {
+		logs.Warn("Rollback plugin failed.", zap.Error(result.Error))
+		m.Abort()
+		return PluginInfo{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		m.Abort()
+		return PluginInfo{}, gorm.ErrRecordNotFound
+	}
+
+	created, _, err := m.appendPluginRevision(PluginInfo(target.Snapshot), registeredBy, PluginRevisionActionRollback, revision, true)
+	if err != nil {
+		m.Abort()
+		return PluginInfo{}, err
+	}
+	err = m.recordRegistryEvent(id, RegistryEventUpdated, created.Revision)
+	if err != nil {
+		m.Abort()
+		return PluginInfo{}, err
+	}
+
+	result = m.tx.Model(&Plugin{}).Where("id = ?", id).UpdateColumn("pinned_revision", created.Revision)
+	if result.Error != nil {
+		logs.Warn("Pin plugin revision failed.", zap.Error(result.Error))
+		m.Abort()
+		return PluginInfo{}, result.Error
+	}
+
+	var plugin Plugin
+	result = m.tx.Where("id = ?", id).First(&plugin)
+	if result.Error != nil {
+		logs.Warn("Find rolled back plugin failed.", zap.Error(result.Error))
+		m.Abort()
+		return PluginInfo{}, result.Error
+	}
+
+	m.tx.Commit()
+	return plugin.Info(), nil
+}
+
+func UnpinPlugin(id string) error {
+	m := GetModel()
+	defer m.Close()
+
+	result := m.tx.Model(&Plugin{}).Where("id = ?", id).UpdateColumn("pinned_revision", 0)
+	if result.Error != nil {
+		logs.Info("Unpin plugin failed.", zap.Error(result.Error))
+		m.Abort()
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		m.Abort()
+		return gorm.ErrRecordNotFound
+	}
+
+	m.tx.Commit()
+	return nil
+}
@@ -0,0 +1,164 @@
+package model
+
+import (
+	"carrota-plugin-center/utils/logs"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ChatTypeGroup   = "group"
+	ChatTypePrivate = "private"
+)
+
+// ChatScope 标识一个会话，群聊为 group + 群号，私聊为 private + 用户 ID
+type ChatScope struct {
+	Agent    string `json:"agent"`
+	ChatType string `json:"chat_type"`
+	ChatID   string `json:"chat_id"`
+}
+
+func NewChatScope(agent string, groupID string, userID string) ChatScope {
+	if groupID != "" {
+		return ChatScope{Agent: agent, ChatType: ChatTypeGroup, ChatID: groupID}
+	}
+	return ChatScope{Agent: agent, ChatType: ChatTypePrivate, ChatID: userID}
+}
+
+func (m MessageInfo) ChatScope() ChatScope {
+	return NewChatScope(m.Agent, m.GroupID, m.UserID)
+}
+
+// PluginScopeRule 控制插件在某些会话中是否可用，字段为空表示匹配任意值。
+// 多条规则同时匹配时，越具体的规则优先（会话 ID > 会话类型 > Agent），同等具体时禁用优先；没有匹配的规则时插件可用
+type PluginScopeRule struct {
+	ID        uint      `json:"id"         gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	PluginID  string    `json:"plugin_id"  gorm:"not null;uniqueIndex:idx_plugin_scope_rule"`
+	Agent     string    `json:"agent"      gorm:"not null;default:'';uniqueIndex:idx_plugin_scope_rule"`
+	ChatType  string    `json:"chat_type"  gorm:"not null;default:'';uniqueIndex:idx_plugin_scope_rule"`
+	ChatID    string    `json:"chat_id"    gorm:"not null;default:'';uniqueIndex:idx_plugin_scope_rule"`
+	Enabled   bool      `json:"enabled"    gorm:"not null"`
+}
+
+func (r PluginScopeRule) matches(scope ChatScope) bool {
+	return (r.Agent == "" || r.Agent == scope.Agent) &&
+		(r.ChatType == "" || r.ChatType == scope.ChatType) &&
+		(r.ChatID == "" || r.ChatID == scope.ChatID)
+}
+
+func (r PluginScopeRule) specificity() int {
+	s := 0
+	if r.Agent != "" {
+		s += 1
+	}
+	if r.ChatType != "" {
+		s += 2
+	}
+	if r.ChatID != "" {
+		s += 4
+	}
+	return s
+}
+
+// 与 PluginScopeRule.matches 和 specificity 保持一致，用于在查询插件列表时过滤
+const (
+	scopeRuleMatchSQL       = "(%[1]s.agent = '' OR %[1]s.agent = @agent) AND (%[1]s.chat_type = '' OR %[1]s.chat_type = @chat_type) AND (%[1]s.chat_id = '' OR %[1]s.chat_id = @chat_id)"
+	scopeRuleSpecificitySQL = "((%[1]s.agent <> '')::int + 2 * (%[1]s.chat_type <> '')::int + 4 * (%[1]s.chat_id <> '')::int)"
+)
+
+func pluginEnabledInScope(tx *gorm.DB, scope ChatScope) *gorm.DB {
+	deny := fmt.Sprintf(scopeRuleMatchSQL, "d")
+	allow := fmt.Sprintf(scopeRuleMatchSQL, "a")
+	return tx.Where(
+		"NOT EXISTS (SELECT 1 FROM plugin_scope_rules d WHERE d.plugin_id = plugins.id AND NOT d.enabled AND "+deny+
+			" AND NOT EXISTS (SELECT 1 FROM plugin_scope_rules a WHERE a.plugin_id = plugins.id AND a.enabled AND "+allow+
+			" AND "+fmt.Sprintf(scopeRuleSpecificitySQL, "a")+" > "+fmt.Sprintf(scopeRuleSpecificitySQL, "d")+"))",
+		map[string]interface{}{"agent": scope.Agent, "chat_type": scope.ChatType, "chat_id": scope.ChatID},
+	)
+}
+
+func IsPluginEnabledInScope(pluginID string, scope ChatScope) (bool, error) {
+	rules, err := FindPluginScopeRules(pluginID)
+	if err != nil {
+		return false, err
+	}
+
+	enabled, best := true, -1
+	for _, rule := range rules {
+		if !rule.matches(scope) {
+			continue
+		}
+		s := rule.specificity()
+		if s > best || (s == best && !rule.Enabled) {
+			enabled, best = rule.Enabled, s
+		}
+	}
+	return enabled, nil
+}
+
+func FindPluginScopeRules(pluginID string) ([]PluginScopeRule, error) {
+	m := GetModel()
+	defer m.Close()
+
+	rules := []PluginScopeRule{}
+	result := m.tx.Where("plugin_id = ?", pluginID).Order("id").Find(&rules)
+	if result.Error != nil {
+		logs.Info("Find plugin scope rules failed.", zap.String("id", pluginID), zap.Error(result.Error))
+		m.Abort()
+		return nil, result.Error
+	}
+
+	m.tx.Commit()
+	return rules, nil
+}
+
+func SetPluginScopeRule(rule PluginScopeRule) (PluginScopeRule, error) {
+	m := GetModel()
+	defer m.Close()
+
+	result := m.tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "plugin_id"}, {Name: "agent"}, {Name: "chat_type"}, {Name: "chat_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"enabled", "updated_at"}),
+	}).Create(&rule)
+	if result.Error != nil {
+		logs.Warn("Set plugin scope rule failed.", zap.Any("rule", rule), zap.Error(result.Error))
+		m.Abort()
+		return PluginScopeRule{}, result.Error
+	}
+
+	var saved PluginScopeRule
+	result = m.tx.Where("plugin_id = ? AND agent = ? AND chat_type = ? AND chat_id = ?", rule.PluginID, rule.Agent, rule.ChatType, rule.ChatID).First(&saved)
+	if result.Error != nil {
+		logs.Warn("Find saved plugin scope rule failed.", zap.Error(result.Error))
+		m.Abort()
+		return PluginScopeRule{}, result.Error
+	}
+
+	m.tx.Commit()
+	return saved, nil
+}
+
+func DeletePluginScopeRule(pluginID string, id uint) error {
+	m := GetModel()
+	defer m.Close()
+
+	result := m.tx.Where("plugin_id = ? AND id = ?", pluginID, id).Delete(&PluginScopeRule{})
+	if result.Error != nil {
+		logs.Info("Delete plugin scope rule failed.", zap.Error(result.Error))
+		m.Abort()
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		m.Abort()
+		return gorm.ErrRecordNotFound
+	}
+
+	m.tx.Commit()
+	return nil
+}
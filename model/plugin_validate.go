@@ -0,0 +1,211 @@
+package model
+
+import (
+	"carrota-plugin-center/utils/schema"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var messages []string
+	for _, f := range e.Fields {
+		messages = append(messages, f.Field+": "+f.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) add(field string, format string, a ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, a...)})
+}
+
+// 参数类型及其别名，注册时统一转换为 JSON Schema 类型名
+var pluginParamTypes = map[string]string{
+	"string":  "string",
+	"str":     "string",
+	"text":    "string",
+	"integer": "integer",
+	"int":     "integer",
+	"long":    "integer",
+	"number":  "number",
+	"float":   "number",
+	"double":  "number",
+	"boolean": "boolean",
+	"bool":    "boolean",
+	"array":   "array",
+	"list":    "array",
+	"object":  "object",
+	"dict":    "object",
+	"map":     "object",
+}
+
+// 插件单次上报的最长超时时间（秒）
+const PluginMaxTimeout = 300
+
+// 插件可覆盖的重试策略上限
+const (
+	PluginMaxRetryAttempts = 10
+	PluginMaxRetryBackoff  = 60000 // 毫秒
+)
+
+var (
+	pluginIDPattern       = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.\-]{0,63}$`)
+	pluginParamKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	formatPlaceholder     = regexp.MustCompile(`\$\{([^{}]*)\}`)
+)
+
+func NormalizePluginParamType(t string) (string, bool) {
+	normalized, ok := pluginParamTypes[strings.ToLower(strings.TrimSpace(t))]
+	return normalized, ok
+}
+
+// Validate 校验插件注册信息，并将参数类型别名规范化。校验失败时返回 *ValidationError
+func (p *PluginInfo) Validate() error {
+	e := &ValidationError{}
+
+	p.ID = strings.TrimSpace(p.ID)
+	if p.ID == "" {
+		e.add("id", "is required")
+	} else if !pluginIDPattern.MatchString(p.ID) {
+		e.add("id", "must be 1-64 characters of letters, digits, '_', '-' or '.', starting with a letter or digit")
+	}
+
+	for _, required := range []struct{ field, value string }{
+		{"name", p.Name},
+		{"author", p.Author},
+		{"description", p.Description},
+		{"prompt", p.Prompt},
+	} {
+		if strings.TrimSpace(required.value) == "" {
+			e.add(required.field, "is required")
+		}
+	}
+
+	if p.Url == "" {
+		e.add("url", "is required")
+	} else if u, err := url.ParseRequestURI(p.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		e.add("url", "must be an absolute http or https URL")
+	}
+
+	if p.HealthUrl != "" {
+		if u, err := url.ParseRequestURI(p.HealthUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			e.add("health_url", "must be an absolute http or https URL")
+		}
+	}
+
+	if p.Timeout < 0 || p.Timeout > PluginMaxTimeout {
+		e.add("timeout", "must be between 0 and %d seconds", PluginMaxTimeout)
+	}
+	if p.ReplyMode == "" {
+		p.ReplyMode = ReplyModeReply
+	} else if !IsValidReplyMode(p.ReplyMode) {
+		e.add("reply_mode", "must be one of %s", strings.Join(ReplyModes, ", "))
+	}
+	if p.WrapperPolicy != "" && !IsValidWrapperPolicy(p.WrapperPolicy) {
+		e.add("wrapper_policy", "must be one of %s", strings.Join(WrapperPolicies, ", "))
+	}
+	if p.Retry != nil {
+		if p.Retry.MaxAttempts < 0 || p.Retry.MaxAttempts > PluginMaxRetryAttempts {
+			e.add("retry.max_attempts", "must be between 0 and %d", PluginMaxRetryAttempts)
+		}
+		if p.Retry.InitialBackoff < 0 || p.Retry.InitialBackoff > PluginMaxRetryBackoff {
+			e.add("retry.initial_backoff", "must be between 0 and %d milliseconds", PluginMaxRetryBackoff)
+		}
+		if p.Retry.MaxBackoff < 0 || p.Retry.MaxBackoff > PluginMaxRetryBackoff {
+			e.add("retry.max_backoff", "must be between 0 and %d milliseconds", PluginMaxRetryBackoff)
+		}
+		for i, code := range p.Retry.RetryOnStatus {
+			if code < 100 || code > 599 {
+				e.add(fmt.Sprintf("retry.retry_on_status[%d]", i), "must be a valid HTTP status code")
+			}
+		}
+	}
+
+	keys := map[string]bool{}
+	for i := range p.Params {
+		param := &p.Params[i]
+		field := fmt.Sprintf("param[%d]", i)
+		if param.Key == "" {
+			e.add(field+".key", "is required")
+		} else if !pluginParamKeyPattern.MatchString(param.Key) {
+			e.add(field+".key", "must be an identifier of letters, digits and '_'")
+		} else if keys[param.Key] {
+			e.add(field+".key", "duplicate key %q", param.Key)
+		}
+		keys[param.Key] = true
+
+		if normalized, ok := NormalizePluginParamType(param.Type); ok {
+			param.Type = normalized
+		} else {
+			e.add(field+".type", "unknown type %q, expected one of string, integer, number, boolean, array, object", param.Type)
+		}
+
+		if strings.TrimSpace(param.Description) == "" {
+			e.add(field+".description", "is required")
+		}
+	}
+
+	if len(p.Schema) > 0 {
+		s, err := schema.Parse(p.Schema)
+		if err != nil {
+			e.add("schema", "invalid JSON Schema: %s", err.Error())
+		} else if s.Type != schema.TypeObject {
+			e.add("schema", schema.ErrNotObject.Error())
+		} else {
+			for key := range s.Properties {
+				keys[key] = true
+			}
+			// 统一格式，避免仅空白或键顺序不同的注册被视为内容变化
+			var canonical interface{}
+			_ = json.Unmarshal(p.Schema, &canonical)
+			p.Schema, _ = json.Marshal(canonical)
+		}
+	}
+
+	for i, format := range p.Format {
+		for _, match := range formatPlaceholder.FindAllStringSubmatch(format, -1) {
+			if !keys[match[1]] {
+				e.add(fmt.Sprintf("format[%d]", i), "placeholder ${%s} is not declared in param or schema", match[1])
+			}
+		}
+	}
+
+	if len(e.Fields) > 0 {
+		return e
+	}
+	return nil
+}
+
+// ParamSchema 返回插件参数的 JSON Schema。未注册 schema 时由 param 生成，只校验参数类型；
+// 两者都没有时返回 nil，表示不对参数做任何处理
+func (p PluginInfo) ParamSchema() (*schema.Schema, error) {
+	if len(p.Schema) > 0 {
+		return schema.Parse(p.Schema)
+	}
+	if len(p.Params) == 0 {
+		return nil, nil
+	}
+	s := &schema.Schema{
+		Type:       schema.TypeObject,
+		Properties: map[string]*schema.Schema{},
+	}
+	for _, param := range p.Params {
+		t, _ := NormalizePluginParamType(param.Type)
+		s.Properties[param.Key] = &schema.Schema{
+			Type:        t,
+			Description: param.Description,
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,80 @@
+package model
+
+import (
+	"carrota-plugin-center/utils/logs"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	RegistryEventAdded    = "added"
+	RegistryEventUpdated  = "updated"
+	RegistryEventRemoved  = "removed"
+	RegistryEventDisabled = "disabled"
+	RegistryEventEnabled  = "enabled"
+)
+
+// 写入注册表事件时持有的事务级 advisory lock，使事件按 ID 顺序提交
+const registryEventLock = 0x63617272 // "carr"
+
+// RegistryEvent 记录插件注册表的变化，自增 ID 即注册表版本号
+type RegistryEvent struct {
+	ID        uint      `json:"version"   gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	PluginID  string    `json:"plugin_id" gorm:"not null;index"`
+	Type      string    `json:"type"      gorm:"not null"`
+	Revision  int       `json:"revision,omitempty"`
+}
+
+// 事件在持有锁后才分配 ID，锁在事务结束时释放，因此较小的 ID 不会晚于较大的 ID 提交，
+// /plugin/watch 按 id > version 读取时不会漏掉事件
+func (m *Model) recordRegistryEvent(pluginID string, eventType string, revision int) error {
+	result := m.tx.Exec("SELECT pg_advisory_xact_lock(?)", registryEventLock)
+	if result.Error != nil {
+		logs.Warn("Lock registry events failed.", zap.String("id", pluginID), zap.Error(result.Error))
+		return result.Error
+	}
+	result = m.tx.Create(&RegistryEvent{
+		PluginID: pluginID,
+		Type:     eventType,
+		Revision: revision,
+	})
+	if result.Error != nil {
+		logs.Warn("Create registry event failed.", zap.String("id", pluginID), zap.String("type", eventType), zap.Error(result.Error))
+		return result.Error
+	}
+	return nil
+}
+
+func FindRegistryEvents(after uint, limit int) ([]RegistryEvent, error) {
+	m := GetModel()
+	defer m.Close()
+
+	events := []RegistryEvent{}
+	result := m.tx.Where("id > ?", after).Order("id").Limit(limit).Find(&events)
+	if result.Error != nil {
+		logs.Info("Find registry events failed.", zap.Error(result.Error))
+		m.Abort()
+		return nil, result.Error
+	}
+
+	m.tx.Commit()
+	return events, nil
+}
+
+func FindRegistryVersion() (uint, error) {
+	m := GetModel()
+	defer m.Close()
+
+	var version uint
+	result := m.tx.Model(&RegistryEvent{}).Select("COALESCE(MAX(id), 0)").Scan(&version)
+	if result.Error != nil {
+		logs.Info("Find registry version failed.", zap.Error(result.Error))
+		m.Abort()
+		return 0, result.Error
+	}
+
+	m.tx.Commit()
+	return version, nil
+}
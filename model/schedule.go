@@ -0,0 +1,442 @@
+package model
+
+import (
+	"carrota-plugin-center/utils/cron"
+	"carrota-plugin-center/utils/logs"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ScheduleActive    = "active"
+	ScheduleCompleted = "completed" // 一次性的定时消息已发送，或 cron 表达式不会再触发
+	ScheduleCancelled = "cancelled"
+)
+
+const (
+	ScheduleRunEnqueued = "enqueued" // 已写入 outbox，投递结果见 delivery_status
+	ScheduleRunFailed   = "failed"
+)
+
+var ErrScheduleNotActive = errors.New("schedule is not active")
+
+// ScheduledMessage 为定时或周期发送的消息，到期后由 scheduler 经 outbox 发送
+type ScheduledMessage struct {
+	ID           uint          `json:"id"            gorm:"primaryKey"`
+	CreatedAt    time.Time     `json:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+	Agent        string        `json:"agent"         gorm:"not null"`
+	GroupID      string        `json:"group_id"      gorm:"not null;default:''"`
+	UserID       string        `json:"user_id"       gorm:"not null;default:''"`
+	ReplyMode    string        `json:"reply_mode"    gorm:"not null;default:''"`
+	PluginID     string        `json:"plugin_id"     gorm:"not null;default:'';index"`
+	Message      OutboxPayload `json:"message"       gorm:"type:jsonb;not null"`
+	SendAt       *time.Time    `json:"send_at"`                                  // 一次性发送的时间
+	Cron         string        `json:"cron"          gorm:"not null;default:''"` // 周期发送的 cron 表达式
+	Timezone     string        `json:"timezone"      gorm:"not null"`
+	Status       string        `json:"status"        gorm:"not null;index:idx_schedule_due,priority:1"`
+	NextRunAt    *time.Time    `json:"next_run_at"   gorm:"index:idx_schedule_due,priority:2"`
+	LastRunAt    *time.Time    `json:"last_run_at"`
+	RunCount     int           `json:"run_count"     gorm:"not null;default:0"`
+	ClaimedUntil *time.Time    `json:"claimed_until"` // 执行中的定时消息在该时间后仍未完成时可被其他 worker 重新执行
+}
+
+// ScheduleRequest 为创建或修改定时消息的请求，send_at 与 cron 必须且只能设置一个
+type ScheduleRequest struct {
+	Agent     string        `json:"agent"`
+	GroupID   string        `json:"group_id"`
+	UserID    string        `json:"user_id"`
+	Message   []RichMessage `json:"message"`
+	ReplyMode string        `json:"reply_mode,omitempty"`
+	PluginID  string        `json:"plugin_id,omitempty"`
+	SendAt    *time.Time    `json:"send_at,omitempty"`
+	Cron      string        `json:"cron,omitempty"`
+	Timezone  string        `json:"timezone,omitempty"`
+}
+
+// Validate 校验定时消息请求，校验失败时返回 *ValidationError
+func (r *ScheduleRequest) Validate(now time.Time) error {
+	e := &ValidationError{}
+
+	if len(r.Message) == 0 {
+		e.add("message", "is required")
+	}
+	if r.GroupID == "" && r.UserID == "" {
+		e.add("group_id", "group_id or user_id is required")
+	}
+	switch r.ReplyMode {
+	case "", ReplyModeGroup:
+	case ReplyModePrivate:
+		if r.UserID == "" {
+			e.add("user_id", "is required for private messages")
+		}
+	default:
+		// 定时消息没有可以引用的原消息
+		e.add("reply_mode", "must be %s or %s", ReplyModeGroup, ReplyModePrivate)
+	}
+
+	loc, err := time.LoadLocation(r.Timezone)
+	if err != nil {
+		e.add("timezone", "unknown timezone %q", r.Timezone)
+	}
+	switch {
+	case r.SendAt != nil && r.Cron != "":
+		e.add("cron", "send_at and cron cannot both be set")
+	case r.SendAt != nil:
+		if !r.SendAt.After(now) {
+			e.add("send_at", "must be in the future")
+		}
+	case r.Cron != "":
+		schedule, err := cron.Parse(r.Cron)
+		if err != nil {
+			e.add("cron", "%s", err.Error())
+		} else if loc != nil && schedule.Next(now.In(loc)).IsZero() {
+			e.add("cron", "never fires")
+		}
+	default:
+		e.add("send_at", "send_at or cron is required")
+	}
+
+	if len(e.Fields) > 0 {
+		return e
+	}
+	return nil
+}
+
+func (r ScheduleRequest) record() ScheduledMessage {
+	return ScheduledMessage{
+		Agent:     r.Agent,
+		GroupID:   r.GroupID,
+		UserID:    r.UserID,
+		ReplyMode: r.ReplyMode,
+		PluginID:  r.PluginID,
+		Message:   OutboxPayload(r.Message),
+		SendAt:    r.SendAt,
+		Cron:      r.Cron,
+		Timezone:  r.Timezone,
+	}
+}
+
+// Target 返回发送的目标会话
+func (s ScheduledMessage) Target() MessageInfo {
+	return MessageInfo{
+		Agent:   s.Agent,
+		GroupID: s.GroupID,
+		UserID:  s.UserID,
+	}
+}
+
+// NextRun 返回 after 之后的下一次发送时间，不会再发送时返回 nil
+func (s ScheduledMessage) NextRun(after time.Time) *time.Time {
+	if s.Cron == "" {
+		if s.SendAt != nil && s.SendAt.After(after) {
+			t := *s.SendAt
+			return &t
+		}
+		return nil
+	}
+
+	schedule, err := cron.Parse(s.Cron)
+	if err != nil {
+		logs.Warn("Parse cron expression failed.", zap.Uint("id", s.ID), zap.String("cron", s.Cron), zap.Error(err))
+		return nil
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		logs.Warn("Load schedule timezone failed.", zap.Uint("id", s.ID), zap.String("timezone", s.Timezone), zap.Error(err))
+		return nil
+	}
+	t := schedule.Next(after.In(loc))
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// 根据下一次发送时间更新状态，不会再发送时标记为已完成
+func (s *ScheduledMessage) reschedule(after time.Time) {
+	s.NextRunAt = s.NextRun(after)
+	s.Status = ScheduleActive
+	if s.NextRunAt == nil {
+		s.Status = ScheduleCompleted
+	}
+}
+
+func CreateScheduledMessage(request ScheduleRequest) (ScheduledMessage, error) {
+	m := GetModel()
+	defer m.Close()
+
+	schedule := request.record()
+	schedule.reschedule(time.Now())
+	result := m.tx.Create(&schedule)
+	if result.Error != nil {
+		logs.Warn("Create scheduled message failed.", zap.String("agent", request.Agent), zap.Error(result.Error))
+		m.Abort()
+		return schedule, result.Error
+	}
+
+	m.tx.Commit()
+	return schedule, nil
+}
+
+// UpdateScheduledMessage 以 request 替换定时消息的目标、内容与发送时间，插件 ID 与发送记录保持不变。
+// 已取消或已完成的定时消息返回 ErrScheduleNotActive
+func UpdateScheduledMessage(id uint, request ScheduleRequest) (ScheduledMessage, error) {
+	m := GetModel()
+	defer m.Close()
+
+	schedule := ScheduledMessage{}
+	result := m.tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&schedule)
+	if result.Error != nil {
+		logs.Info("Find scheduled message failed.", zap.Uint("id", id), zap.Error(result.Error))
+		m.Abort()
+		return schedule, result.Error
+	}
+	if schedule.Status != ScheduleActive {
+		m.Abort()
+		return schedule, ErrScheduleNotActive
+	}
+
+	updated := request.record()
+	updated.ID = schedule.ID
+	updated.CreatedAt = schedule.CreatedAt
+	updated.PluginID = schedule.PluginID
+	updated.LastRunAt = schedule.LastRunAt
+	updated.RunCount = schedule.RunCount
+	updated.reschedule(time.Now())
+	result = m.tx.Select("*").Omit("created_at").Save(&updated)
+	if result.Error != nil {
+		logs.Warn("Update scheduled message failed.", zap.Uint("id", id), zap.Error(result.Error))
+		m.Abort()
+		return schedule, result.Error
+	}
+
+	m.tx.Commit()
+	return updated, nil
+}
+
+// CancelScheduledMessage 取消定时消息，已经写入 outbox 的消息不受影响。已取消或已完成时返回 ErrScheduleNotActive
+func CancelScheduledMessage(id uint) (ScheduledMessage, error) {
+	m := GetModel()
+	defer m.Close()
+
+	schedule := ScheduledMessage{}
+	result := m.tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&schedule)
+	if result.Error != nil {
+		logs.Info("Find scheduled message failed.", zap.Uint("id", id), zap.Error(result.Error))
+		m.Abort()
+		return schedule, result.Error
+	}
+	if schedule.Status != ScheduleActive {
+		m.Abort()
+		return schedule, ErrScheduleNotActive
+	}
+
+	result = m.tx.Model(&schedule).Updates(map[string]interface{}{
+		"status":        ScheduleCancelled,
+		"next_run_at":   nil,
+		"claimed_until": nil,
+	})
+	if result.Error != nil {
+		logs.Warn("Cancel scheduled message failed.", zap.Uint("id", id), zap.Error(result.Error))
+		m.Abort()
+		return schedule, result.Error
+	}
+
+	m.tx.Commit()
+	schedule.Status = ScheduleCancelled
+	schedule.NextRunAt = nil
+	schedule.ClaimedUntil = nil
+	return schedule, nil
+}
+
+// ClaimDueScheduledMessage 取出一条到期、且没有被其他 worker 执行中的定时消息，持有 lease 时长的租约，
+// 没有到期的消息时返回 nil。返回的 NextRunAt 为本次的计划发送时间
+func ClaimDueScheduledMessage(lease time.Duration) (*ScheduledMessage, error) {
+	m := GetModel()
+	defer m.Close()
+
+	schedule := ScheduledMessage{}
+	now := time.Now()
+	result := m.tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ? AND next_run_at <= ? AND (claimed_until IS NULL OR claimed_until <= ?)", ScheduleActive, now, now).
+		Order("next_run_at, id").Limit(1).Find(&schedule)
+	if result.Error != nil {
+		logs.Warn("Claim scheduled message failed.", zap.Error(result.Error))
+		m.Abort()
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		m.tx.Commit()
+		return nil, nil
+	}
+
+	// 数据库只保存到微秒，完成时以该值确认租约仍属于自己
+	claimedUntil := now.Add(lease).Truncate(time.Microsecond)
+	result = m.tx.Model(&schedule).Update("claimed_until", claimedUntil)
+	if result.Error != nil {
+		logs.Warn("Claim scheduled message failed.", zap.Uint("id", schedule.ID), zap.Error(result.Error))
+		m.Abort()
+		return nil, result.Error
+	}
+
+	m.tx.Commit()
+	schedule.ClaimedUntil = &claimedUntil
+	return &schedule, nil
+}
+
+// FinishScheduledMessageRun 在同一事务中写入本次发送的 outbox 消息与执行记录，并计算下一次发送时间。
+// outbox 为 nil 时记录执行失败的原因 runErr。center 停止期间错过的多次发送只补发一次。
+// 租约已过期并被重新取出，或定时消息已被修改、取消时不做任何修改，返回 false
+func FinishScheduledMessageRun(schedule ScheduledMessage, outbox *OutboxMessage, runErr error) (ScheduleRun, bool, error) {
+	m := GetModel()
+	defer m.Close()
+
+	run := ScheduleRun{
+		ScheduleID:  schedule.ID,
+		ScheduledAt: *schedule.NextRunAt,
+		Status:      ScheduleRunEnqueued,
+	}
+	now := time.Now()
+	schedule.reschedule(now)
+	result := m.tx.Model(&ScheduledMessage{}).
+		Where("id = ? AND status = ? AND claimed_until = ?", schedule.ID, ScheduleActive, *schedule.ClaimedUntil).
+		Updates(map[string]interface{}{
+			"status":        schedule.Status,
+			"next_run_at":   schedule.NextRunAt,
+			"last_run_at":   now,
+			"run_count":     gorm.Expr("run_count + 1"),
+			"claimed_until": nil,
+		})
+	if result.Error != nil {
+		logs.Warn("Finish scheduled message run failed.", zap.Uint("id", schedule.ID), zap.Error(result.Error))
+		m.Abort()
+		return run, false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		m.Abort()
+		return run, false, nil
+	}
+
+	if outbox != nil {
+		result = m.tx.Create(outbox)
+		if result.Error != nil {
+			logs.Warn("Create outbox message failed.", zap.Uint("schedule_id", schedule.ID), zap.Error(result.Error))
+			m.Abort()
+			return run, false, result.Error
+		}
+		run.OutboxID = &outbox.ID
+	} else {
+		run.Status = ScheduleRunFailed
+		if runErr != nil {
+			run.Error = runErr.Error()
+		}
+	}
+	result = m.tx.Create(&run)
+	if result.Error != nil {
+		logs.Warn("Create schedule run failed.", zap.Uint("schedule_id", schedule.ID), zap.Error(result.Error))
+		m.Abort()
+		return run, false, result.Error
+	}
+
+	m.tx.Commit()
+	return run, true, nil
+}
+
+func FindScheduledMessageById(id uint) (ScheduledMessage, error) {
+	m := GetModel()
+	defer m.Close()
+
+	schedule := ScheduledMessage{}
+	result := m.tx.Where("id = ?", id).First(&schedule)
+	if result.Error != nil {
+		logs.Info("Find scheduled message by id failed.", zap.Uint("id", id), zap.Error(result.Error))
+		m.Abort()
+		return schedule, result.Error
+	}
+
+	m.tx.Commit()
+	return schedule, nil
+}
+
+// FindScheduledMessages 按创建时间倒序返回定时消息，pluginID 与 status 为空时不按该字段过滤
+func FindScheduledMessages(pluginID string, status string, limit int) ([]ScheduledMessage, error) {
+	m := GetModel()
+	defer m.Close()
+
+	schedules := []ScheduledMessage{}
+	tx := m.tx
+	if pluginID != "" {
+		tx = tx.Where("plugin_id = ?", pluginID)
+	}
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	result := tx.Order("id DESC").Limit(limit).Find(&schedules)
+	if result.Error != nil {
+		logs.Info("Find scheduled messages failed.", zap.Error(result.Error))
+		m.Abort()
+		return nil, result.Error
+	}
+
+	m.tx.Commit()
+	return schedules, nil
+}
+
+// ScheduleRun 为定时消息的一次执行记录
+type ScheduleRun struct {
+	ID             uint      `json:"id"              gorm:"primaryKey"`
+	CreatedAt      time.Time `json:"created_at"`
+	ScheduleID     uint      `json:"schedule_id"     gorm:"not null;index"`
+	ScheduledAt    time.Time `json:"scheduled_at"    gorm:"not null"` // 计划发送的时间
+	OutboxID       *uint     `json:"outbox_id"`
+	Status         string    `json:"status"          gorm:"not null"`
+	Error          string    `json:"error"           gorm:"type:text;not null;default:''"`
+	DeliveryStatus string    `json:"delivery_status" gorm:"-"` // outbox 中的投递状态
+}
+
+// FindScheduleRuns 按时间倒序返回定时消息的执行记录，并附带对应 outbox 消息的投递状态
+func FindScheduleRuns(scheduleID uint, limit int) ([]ScheduleRun, error) {
+	m := GetModel()
+	defer m.Close()
+
+	runs := []ScheduleRun{}
+	result := m.tx.Where("schedule_id = ?", scheduleID).Order("id DESC").Limit(limit).Find(&runs)
+	if result.Error != nil {
+		logs.Info("Find schedule runs failed.", zap.Uint("schedule_id", scheduleID), zap.Error(result.Error))
+		m.Abort()
+		return nil, result.Error
+	}
+
+	outboxIDs := []uint{}
+	for _, run := range runs {
+		if run.OutboxID != nil {
+			outboxIDs = append(outboxIDs, *run.OutboxID)
+		}
+	}
+	if len(outboxIDs) > 0 {
+		outboxes := []OutboxMessage{}
+		result = m.tx.Select("id", "status").Where("id IN ?", outboxIDs).Find(&outboxes)
+		if result.Error != nil {
+			logs.Info("Find schedule run outbox messages failed.", zap.Uint("schedule_id", scheduleID), zap.Error(result.Error))
+			m.Abort()
+			return nil, result.Error
+		}
+		statuses := map[uint]string{}
+		for _, outbox := range outboxes {
+			statuses[outbox.ID] = outbox.Status
+		}
+		for i := range runs {
+			if runs[i].OutboxID != nil {
+				runs[i].DeliveryStatus = statuses[*runs[i].OutboxID]
+			}
+		}
+	}
+
+	m.tx.Commit()
+	return runs, nil
+}
@@ -0,0 +1,156 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+const (
+	SegmentText    = "text"
+	SegmentMention = "mention"
+	SegmentImage   = "image"
+	SegmentFile    = "file"
+	SegmentQuote   = "quote"
+	SegmentLink    = "link"
+)
+
+// Segment 为消息中的一个片段，不同类型使用不同的字段：
+// text 使用 Text；mention 使用 UserID 与 UserName；image 使用 Url；
+// file 使用 Url 与 Name；quote 使用 MessageID；link 使用 Url 与可选的 Text
+type Segment struct {
+	Type      string `json:"type"`
+	Text      string `json:"text,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+	UserName  string `json:"user_name,omitempty"`
+	Url       string `json:"url,omitempty"`
+	Name      string `json:"name,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+var ErrInvalidSegment = errors.New("invalid message segment")
+
+func (s Segment) valid() bool {
+	switch s.Type {
+	case SegmentText:
+		return true
+	case SegmentMention:
+		return s.UserID != ""
+	case SegmentImage, SegmentFile, SegmentLink:
+		return s.Url != ""
+	case SegmentQuote:
+		return s.MessageID != ""
+	}
+	return false
+}
+
+// PlainText 为片段的纯文本形式，供不支持富文本的插件、Wrapper 与会话历史使用
+func (s Segment) PlainText() string {
+	switch s.Type {
+	case SegmentText:
+		return s.Text
+	case SegmentMention:
+		if s.UserName != "" {
+			return "@" + s.UserName
+		}
+		return "@" + s.UserID
+	case SegmentImage:
+		return "[图片]"
+	case SegmentFile:
+		if s.Name != "" {
+			return "[文件 " + s.Name + "]"
+		}
+		return "[文件]"
+	case SegmentLink:
+		if s.Text != "" {
+			return s.Text + " (" + s.Url + ")"
+		}
+		return s.Url
+	}
+	return ""
+}
+
+// RichMessage 为由多个片段组成的一条消息。
+// JSON 中既可以是字符串（等同于一个 text 片段），也可以是片段数组；只有一个 text 片段时序列化为字符串
+type RichMessage []Segment
+
+func TextMessage(text string) RichMessage {
+	return RichMessage{{Type: SegmentText, Text: text}}
+}
+
+func (r *RichMessage) UnmarshalJSON(b []byte) error {
+	var text string
+	if err := json.Unmarshal(b, &text); err == nil {
+		*r = TextMessage(text)
+		return nil
+	}
+	var segments []Segment
+	if err := json.Unmarshal(b, &segments); err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if !s.valid() {
+			return ErrInvalidSegment
+		}
+	}
+	*r = segments
+	return nil
+}
+
+func (r RichMessage) MarshalJSON() ([]byte, error) {
+	if r.IsPlain() {
+		return json.Marshal(r.PlainText())
+	}
+	return json.Marshal([]Segment(r))
+}
+
+// IsPlain 判断消息是否只包含文字
+func (r RichMessage) IsPlain() bool {
+	return len(r) == 0 || (len(r) == 1 && r[0].Type == SegmentText)
+}
+
+func (r RichMessage) PlainText() string {
+	b := strings.Builder{}
+	for _, s := range r {
+		b.WriteString(s.PlainText())
+	}
+	return b.String()
+}
+
+// Attachments 返回消息中无法以文字表示的图片与文件片段
+func (r RichMessage) Attachments() RichMessage {
+	attachments := RichMessage{}
+	for _, s := range r {
+		if s.Type == SegmentImage || s.Type == SegmentFile {
+			attachments = append(attachments, s)
+		}
+	}
+	return attachments
+}
+
+// References 返回消息中引用其他消息或用户的 quote 与 mention 片段，这些片段转换为纯文本后无法还原
+func (r RichMessage) References() RichMessage {
+	references := RichMessage{}
+	for _, s := range r {
+		if s.Type == SegmentQuote || s.Type == SegmentMention {
+			references = append(references, s)
+		}
+	}
+	return references
+}
+
+func TextMessages(texts []string) []RichMessage {
+	messages := make([]RichMessage, 0, len(texts))
+	for _, text := range texts {
+		messages = append(messages, TextMessage(text))
+	}
+	return messages
+}
+
+func PlainTexts(messages []RichMessage) []string {
+	texts := make([]string, 0, len(messages))
+	for _, message := range messages {
+		texts = append(texts, message.PlainText())
+	}
+	return texts
+}
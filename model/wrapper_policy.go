@@ -0,0 +1,128 @@
+package model
+
+import (
+	"carrota-plugin-center/utils/logs"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	WrapperPolicyAlways   = "always"   // 总是经过 Wrapper 包装，Wrapper 请求失败时不发送
+	WrapperPolicyFallback = "fallback" // 经过 Wrapper 包装，Wrapper 请求失败时发送插件的原始回复
+	WrapperPolicyNever    = "never"    // 不经过 Wrapper，直接发送插件的原始回复
+)
+
+var WrapperPolicies = []string{WrapperPolicyAlways, WrapperPolicyFallback, WrapperPolicyNever}
+
+func IsValidWrapperPolicy(policy string) bool {
+	for _, p := range WrapperPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// WrapperPolicyRule 为会话设置 Wrapper 策略，字段为空表示匹配任意值，多条规则同时匹配时越具体的规则优先（会话 ID > 会话类型 > Agent）
+type WrapperPolicyRule struct {
+	ID        uint      `json:"id"         gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Agent     string    `json:"agent"      gorm:"not null;default:'';uniqueIndex:idx_wrapper_policy_rule"`
+	ChatType  string    `json:"chat_type"  gorm:"not null;default:'';uniqueIndex:idx_wrapper_policy_rule"`
+	ChatID    string    `json:"chat_id"    gorm:"not null;default:'';uniqueIndex:idx_wrapper_policy_rule"`
+	Policy    string    `json:"policy"     gorm:"not null"`
+}
+
+func (r WrapperPolicyRule) matches(scope ChatScope) bool {
+	return (r.Agent == "" || r.Agent == scope.Agent) &&
+		(r.ChatType == "" || r.ChatType == scope.ChatType) &&
+		(r.ChatID == "" || r.ChatID == scope.ChatID)
+}
+
+func (r WrapperPolicyRule) specificity() int {
+	return PluginScopeRule{Agent: r.Agent, ChatType: r.ChatType, ChatID: r.ChatID}.specificity()
+}
+
+// FindChatWrapperPolicy 返回会话的 Wrapper 策略，没有匹配的规则时返回空字符串
+func FindChatWrapperPolicy(scope ChatScope) (string, error) {
+	rules, err := FindWrapperPolicyRules()
+	if err != nil {
+		return "", err
+	}
+
+	policy, best := "", -1
+	for _, rule := range rules {
+		if !rule.matches(scope) {
+			continue
+		}
+		if s := rule.specificity(); s > best {
+			policy, best = rule.Policy, s
+		}
+	}
+	return policy, nil
+}
+
+func FindWrapperPolicyRules() ([]WrapperPolicyRule, error) {
+	m := GetModel()
+	defer m.Close()
+
+	rules := []WrapperPolicyRule{}
+	result := m.tx.Order("id").Find(&rules)
+	if result.Error != nil {
+		logs.Info("Find wrapper policy rules failed.", zap.Error(result.Error))
+		m.Abort()
+		return nil, result.Error
+	}
+
+	m.tx.Commit()
+	return rules, nil
+}
+
+func SetWrapperPolicyRule(rule WrapperPolicyRule) (WrapperPolicyRule, error) {
+	m := GetModel()
+	defer m.Close()
+
+	result := m.tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "agent"}, {Name: "chat_type"}, {Name: "chat_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"policy", "updated_at"}),
+	}).Create(&rule)
+	if result.Error != nil {
+		logs.Warn("Set wrapper policy rule failed.", zap.Any("rule", rule), zap.Error(result.Error))
+		m.Abort()
+		return WrapperPolicyRule{}, result.Error
+	}
+
+	var saved WrapperPolicyRule
+	result = m.tx.Where("agent = ? AND chat_type = ? AND chat_id = ?", rule.Agent, rule.ChatType, rule.ChatID).First(&saved)
+	if result.Error != nil {
+		logs.Warn("Find saved wrapper policy rule failed.", zap.Error(result.Error))
+		m.Abort()
+		return WrapperPolicyRule{}, result.Error
+	}
+
+	m.tx.Commit()
+	return saved, nil
+}
+
+func DeleteWrapperPolicyRule(id uint) error {
+	m := GetModel()
+	defer m.Close()
+
+	result := m.tx.Where("id = ?", id).Delete(&WrapperPolicyRule{})
+	if result.Error != nil {
+		logs.Info("Delete wrapper policy rule failed.", zap.Error(result.Error))
+		m.Abort()
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		m.Abort()
+		return gorm.ErrRecordNotFound
+	}
+
+	m.tx.Commit()
+	return nil
+}
@@ -2,6 +2,7 @@ package router
 
 import (
 	"carrota-plugin-center/controllers"
+	"carrota-plugin-center/controllers/middleware"
 
 	"github.com/labstack/echo/v4"
 	echoMiddleware "github.com/labstack/echo/v4/middleware"
@@ -21,11 +22,38 @@ func routes(e *echo.Echo) {
 	e.GET(apiVersionUrl+"/", controllers.IndexGET)
 
 	e.GET(apiVersionUrl+"/health", controllers.HealthGET)
+	e.GET(apiVersionUrl+"/metrics", controllers.MetricsGET)
 
 	pluginGroup := e.Group(apiVersionUrl + "/plugin")
 	{
 		pluginGroup.POST("/register", controllers.PluginRegisterPOST)
 		pluginGroup.GET("/list", controllers.PluginListGET)
+		pluginGroup.GET("/watch", controllers.PluginWatchGET)
+		pluginGroup.GET("/:id", controllers.PluginGET)
+		pluginGroup.DELETE("/:id", controllers.PluginDELETE, middleware.AdminOrPluginOwnerVerificationMiddleware)
+		pluginGroup.POST("/:id/restore", controllers.PluginRestorePOST, middleware.AdminVerificationMiddleware)
+		pluginGroup.GET("/:id/revisions", controllers.PluginRevisionsGET)
+		pluginGroup.GET("/:id/revisions/:revision", controllers.PluginRevisionGET)
+		pluginGroup.POST("/:id/revisions/:revision/rollback", controllers.PluginRollbackPOST, middleware.AdminOrPluginOwnerVerificationMiddleware)
+		pluginGroup.DELETE("/:id/pin", controllers.PluginPinDELETE, middleware.AdminOrPluginOwnerVerificationMiddleware)
+		pluginGroup.POST("/:id/token/reset", controllers.PluginTokenResetPOST, middleware.AdminVerificationMiddleware)
+		pluginGroup.GET("/:id/scope", controllers.PluginScopeGET)
+		pluginGroup.PUT("/:id/scope", controllers.PluginScopePUT, middleware.AdminVerificationMiddleware)
+		pluginGroup.DELETE("/:id/scope/:rule_id", controllers.PluginScopeDELETE, middleware.AdminVerificationMiddleware)
+	}
+
+	adminGroup := e.Group(apiVersionUrl+"/admin", middleware.AdminVerificationMiddleware)
+	{
+		adminGroup.GET("/agents", controllers.AdminAgentsGET)
+		adminGroup.GET("/agents/:name", controllers.AdminAgentGET)
+		adminGroup.PUT("/agents/:name", controllers.AdminAgentPUT)
+		adminGroup.DELETE("/agents/:name", controllers.AdminAgentDELETE)
+		adminGroup.GET("/breakers", controllers.AdminBreakersGET)
+		adminGroup.GET("/messages", controllers.AdminMessagesGET)
+		adminGroup.GET("/messages/:id", controllers.AdminMessageGET)
+		adminGroup.GET("/wrapper-policies", controllers.AdminWrapperPoliciesGET)
+		adminGroup.PUT("/wrapper-policies", controllers.AdminWrapperPolicyPUT)
+		adminGroup.DELETE("/wrapper-policies/:id", controllers.AdminWrapperPolicyDELETE)
 	}
 
 	messageGroup := e.Group(apiVersionUrl + "/message")
@@ -33,5 +61,12 @@ func routes(e *echo.Echo) {
 		messageGroup.POST("", controllers.MessagePOST)
 		messageGroup.POST("/", controllers.MessagePOST)
 		messageGroup.POST("/send", controllers.MessageSendPOST)
+		messageGroup.GET("/send/:id", controllers.MessageSendGET)
+		messageGroup.POST("/schedule", controllers.MessageSchedulePOST)
+		messageGroup.GET("/schedule", controllers.MessageSchedulesGET)
+		messageGroup.GET("/schedule/:id", controllers.MessageScheduleGET)
+		messageGroup.PUT("/schedule/:id", controllers.MessageSchedulePUT)
+		messageGroup.DELETE("/schedule/:id", controllers.MessageScheduleDELETE)
+		messageGroup.GET("/schedule/:id/runs", controllers.MessageScheduleRunsGET)
 	}
 }
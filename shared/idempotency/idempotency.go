@@ -0,0 +1,29 @@
+package idempotency
+
+import (
+	"carrota-plugin-center/model"
+	"carrota-plugin-center/utils/logs"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 每隔该时间删除一次过期的幂等记录
+const cleanupInterval = time.Hour
+
+// Run 定期清理过期的幂等记录
+func Run() {
+	go cleanup()
+}
+
+func cleanup() {
+	for {
+		deleted, err := model.DeleteExpiredIdempotencyRecords(time.Now())
+		if err != nil {
+			logs.Warn("Clean up idempotency records failed", zap.Error(err))
+		} else if deleted > 0 {
+			logs.Debug("Cleaned up idempotency records", zap.Int64("deleted", deleted))
+		}
+		time.Sleep(cleanupInterval)
+	}
+}
@@ -0,0 +1,98 @@
+package outbox
+
+import (
+	"carrota-plugin-center/model"
+	"carrota-plugin-center/shared/service"
+	"carrota-plugin-center/utils/logs"
+	"carrota-plugin-center/utils/metrics"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 没有新消息通知时，空闲的 worker 每隔该时间检查一次是否有到期的消息
+const pollInterval = time.Second
+
+var notify chan struct{}
+
+// Handler 将一条消息投递到 Agent，返回 Agent 的响应；返回的错误会作为失败原因记录
+type Handler func(outbox model.OutboxMessage) (string, error)
+
+// Run 启动 service.OutboxWorkers 个 worker 投递到期的消息。
+// 上次运行时未投递完的消息在租约过期后由任意实例的 worker 重新投递
+func Run(handler Handler) {
+	notify = make(chan struct{}, service.OutboxWorkers)
+
+	logs.Info("Outbox dispatcher started.", zap.Int("workers", service.OutboxWorkers))
+	for i := 0; i < service.OutboxWorkers; i++ {
+		go worker(handler)
+	}
+}
+
+// Notify 通知空闲的 worker 有新消息待发送
+func Notify() {
+	if notify == nil {
+		return
+	}
+	select {
+	case notify <- struct{}{}:
+	default:
+	}
+}
+
+// 重试无法解决的错误：Agent 未注册、不支持该消息，或 Agent 返回了除 408、429 以外的 4xx
+func retryable(err error) bool {
+	if errors.Is(err, model.ErrUnknownAgent) || errors.Is(err, model.ErrAgentCapability) {
+		return false
+	}
+	statusErr := &service.StatusError{}
+	if errors.As(err, &statusErr) && statusErr.StatusCode >= 400 && statusErr.StatusCode < 500 {
+		return statusErr.StatusCode == http.StatusRequestTimeout || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+func worker(handler Handler) {
+	for {
+		outbox, err := model.ClaimOutboxMessage(service.OutboxLease)
+		if err != nil || outbox == nil {
+			select {
+			case <-notify:
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+
+		response, err := deliver(handler, outbox)
+		var retryAt *time.Time
+		switch {
+		case err == nil:
+			logs.Debug("Outbox message delivered", zap.Uint("id", outbox.ID), zap.String("agent", outbox.Agent), zap.Int("attempts", outbox.Attempts))
+			metrics.Inc("outbox_delivered", outbox.Agent)
+		case retryable(err) && outbox.Attempts < service.OutboxRetryPolicy.MaxAttempts:
+			t := time.Now().Add(service.OutboxRetryPolicy.Backoff(outbox.Attempts))
+			retryAt = &t
+			logs.Warn("Deliver outbox message failed, will retry", zap.Uint("id", outbox.ID), zap.String("agent", outbox.Agent), zap.Int("attempts", outbox.Attempts), zap.Time("retry_at", t), zap.Error(err))
+			metrics.Inc("outbox_retries", outbox.Agent)
+		default:
+			logs.Error("Deliver outbox message failed", zap.Uint("id", outbox.ID), zap.String("agent", outbox.Agent), zap.Int("attempts", outbox.Attempts), zap.Error(err))
+			metrics.Inc("outbox_failed", outbox.Agent)
+		}
+		finished, err := model.FinishOutboxMessage(outbox.ID, outbox.Attempts, response, err, retryAt)
+		if err == nil && !finished {
+			logs.Warn("Outbox message was reclaimed before delivery finished", zap.Uint("id", outbox.ID), zap.Int("attempts", outbox.Attempts))
+		}
+	}
+}
+
+func deliver(handler Handler, outbox *model.OutboxMessage) (response string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(*outbox)
+}
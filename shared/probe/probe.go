@@ -0,0 +1,77 @@
+package probe
+
+import (
+	"carrota-plugin-center/model"
+	"carrota-plugin-center/shared/service"
+	"carrota-plugin-center/utils/logs"
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const maxConcurrentProbes = 8
+
+// Run 按配置的间隔探测所有声明了 health_url 的插件，间隔为 0 时直接返回
+func Run() {
+	if service.PluginHealthCheckInterval <= 0 {
+		logs.Info("Plugin health check is disabled.")
+		return
+	}
+	logs.Info("Plugin health check started.", zap.Duration("interval", service.PluginHealthCheckInterval), zap.Duration("timeout", service.PluginHealthCheckTimeout))
+
+	ticker := time.NewTicker(service.PluginHealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		probeAll()
+		<-ticker.C
+	}
+}
+
+func probeAll() {
+	plugins, err := model.FindPluginsWithHealthUrl()
+	if err != nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentProbes)
+	for _, plugin := range plugins {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(plugin model.PluginInfo) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			Probe(plugin)
+		}(plugin)
+	}
+	wg.Wait()
+}
+
+// Probe 探测单个插件并记录结果，返回状态码不为 2xx 或超时均视为不健康
+func Probe(plugin model.PluginInfo) {
+	ctx, cancel := context.WithTimeout(context.Background(), service.PluginHealthCheckTimeout)
+	defer cancel()
+
+	status, healthErr := model.PluginHealthHealthy, ""
+	start := time.Now()
+	req, _ := http.NewRequestWithContext(ctx, "GET", plugin.HealthUrl, nil)
+	resp, err := http.DefaultClient.Do(req)
+	latency := time.Since(start)
+	if err != nil {
+		status, healthErr = model.PluginHealthUnhealthy, err.Error()
+	} else {
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			status, healthErr = model.PluginHealthUnhealthy, "unexpected status code "+strconv.Itoa(resp.StatusCode)
+		}
+	}
+
+	if status != plugin.HealthStatus {
+		logs.Info("Plugin health changed", zap.String("id", plugin.ID), zap.String("from", plugin.HealthStatus), zap.String("to", status), zap.Duration("latency", latency), zap.String("error", healthErr))
+	}
+	model.RecordPluginHealth(plugin.ID, status, latency, healthErr)
+}
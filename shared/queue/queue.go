@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"carrota-plugin-center/model"
+	"carrota-plugin-center/shared/service"
+	"carrota-plugin-center/utils/logs"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 没有新消息通知时，空闲的 worker 每隔该时间检查一次队列
+const pollInterval = time.Second
+
+// 每隔该时间删除一次超过保留时间的已完成消息
+const cleanupInterval = time.Hour
+
+var notify chan struct{}
+
+// Handler 处理一条消息，返回的错误会作为失败原因记录
+type Handler func(message model.MessageInfo) error
+
+// Run 启动 service.QueueWorkers 个 worker 处理队列，并定期清理已完成的消息。
+// 上次运行时未处理完的消息在租约过期后由任意实例的 worker 重新处理
+func Run(handler Handler) {
+	notify = make(chan struct{}, service.QueueWorkers)
+
+	logs.Info("Message queue started.", zap.Int("workers", service.QueueWorkers))
+	for i := 0; i < service.QueueWorkers; i++ {
+		go worker(handler)
+	}
+	go cleanup()
+}
+
+// Notify 通知空闲的 worker 有新消息入队
+func Notify() {
+	if notify == nil {
+		return
+	}
+	select {
+	case notify <- struct{}{}:
+	default:
+	}
+}
+
+func worker(handler Handler) {
+	for {
+		queued, err := model.ClaimQueuedMessage(service.QueueLease, service.QueueMaxAttempts)
+		if err != nil || queued == nil {
+			select {
+			case <-notify:
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+
+		err = process(handler, queued)
+		if err != nil {
+			logs.Warn("Process queued message failed", zap.Uint("id", queued.ID), zap.String("message_id", queued.MessageID), zap.Error(err))
+		}
+		finished, err := model.FinishQueuedMessage(queued.ID, queued.Attempts, err)
+		if err == nil && !finished {
+			logs.Warn("Queued message was reclaimed before processing finished", zap.Uint("id", queued.ID), zap.Int("attempts", queued.Attempts))
+		}
+	}
+}
+
+func cleanup() {
+	for {
+		deleted, err := model.DeleteDoneQueuedMessages(time.Now().Add(-service.QueueRetention))
+		if err != nil {
+			logs.Warn("Clean up queued messages failed", zap.Error(err))
+		} else if deleted > 0 {
+			logs.Debug("Cleaned up queued messages", zap.Int64("deleted", deleted))
+		}
+		time.Sleep(cleanupInterval)
+	}
+}
+
+func process(handler Handler, queued *model.QueuedMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(queued.Message())
+}
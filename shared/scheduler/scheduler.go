@@ -0,0 +1,82 @@
+package scheduler
+
+import (
+	"carrota-plugin-center/model"
+	"carrota-plugin-center/shared/outbox"
+	"carrota-plugin-center/shared/service"
+	"carrota-plugin-center/utils/logs"
+	"carrota-plugin-center/utils/metrics"
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 没有到期的定时消息时，worker 每隔该时间检查一次
+const pollInterval = time.Second
+
+// 同时执行定时消息的数量，单条定时消息的 Wrapper 请求没有响应时不影响其他定时消息
+const workers = 2
+
+// Handler 将到期的定时消息经 Wrapper 包装，返回待写入 outbox 的消息；返回的错误会记录到执行记录
+type Handler func(ctx context.Context, schedule model.ScheduledMessage) (model.OutboxMessage, error)
+
+// Run 启动 scheduler，到期的定时消息交给 handler 包装后，与下一次发送时间在同一事务中写入 outbox。
+// 执行中途 center 退出时，该次发送在租约过期后由任意实例重新执行
+func Run(handler Handler) {
+	logs.Info("Message scheduler started.", zap.Int("workers", workers))
+	for i := 0; i < workers; i++ {
+		go worker(handler)
+	}
+}
+
+// 每次执行的期限，租约留出写入结果的时间，避免正常完成的执行被其他 worker 重新取出
+func timeout() time.Duration {
+	return service.MessageSyncTimeout
+}
+
+func worker(handler Handler) {
+	for {
+		schedule, err := model.ClaimDueScheduledMessage(timeout() + 30*time.Second)
+		if err != nil || schedule == nil {
+			time.Sleep(pollInterval)
+			continue
+		}
+		execute(handler, *schedule)
+	}
+}
+
+func execute(handler Handler, schedule model.ScheduledMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout())
+	defer cancel()
+
+	var pending *model.OutboxMessage
+	message, err := prepare(ctx, handler, schedule)
+	if err == nil {
+		pending = &message
+	}
+	run, finished, finishErr := model.FinishScheduledMessageRun(schedule, pending, err)
+	switch {
+	case finishErr != nil:
+		logs.Error("Record scheduled message run failed", zap.Uint("id", schedule.ID), zap.Error(finishErr))
+	case !finished:
+		logs.Warn("Scheduled message was changed or reclaimed before the run finished", zap.Uint("id", schedule.ID), zap.Time("scheduled_at", run.ScheduledAt))
+	case err != nil:
+		logs.Warn("Run scheduled message failed", zap.Uint("id", schedule.ID), zap.String("agent", schedule.Agent), zap.Time("scheduled_at", run.ScheduledAt), zap.Error(err))
+		metrics.Inc("schedule_runs_failed", schedule.Agent)
+	default:
+		logs.Debug("Scheduled message enqueued", zap.Uint("id", schedule.ID), zap.Uint("outbox_id", *run.OutboxID), zap.Time("scheduled_at", run.ScheduledAt))
+		metrics.Inc("schedule_runs", schedule.Agent)
+		outbox.Notify()
+	}
+}
+
+func prepare(ctx context.Context, handler Handler, schedule model.ScheduledMessage) (message model.OutboxMessage, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, schedule)
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"carrota-plugin-center/utils/logs"
+	"carrota-plugin-center/utils/metrics"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type BreakerConfig struct {
+	FailureThreshold    int `config:"failure-threshold"`      // 连续失败该次数后熔断
+	CoolDown            int `config:"cool-down"`              // 秒，熔断后经过该时间进入半开状态
+	HalfOpenMaxRequests int `config:"half-open-max-requests"` // 半开状态下同时放行的试探请求数
+}
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+var BreakerFailureThreshold = 5
+var BreakerCoolDown = 30 * time.Second
+var BreakerHalfOpenMaxRequests = 1
+
+// 熔断器，按下游地址区分
+type Breaker struct {
+	mu          sync.Mutex
+	name        string
+	state       string
+	failures    int
+	inflight    int
+	openedAt    time.Time
+	lastFailure time.Time
+	lastError   string
+}
+
+type BreakerInfo struct {
+	Name                string     `json:"name"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	LastFailureAt       *time.Time `json:"last_failure_at,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+var breakers = map[string]*Breaker{}
+var breakersMu sync.Mutex
+
+// 获取 name 对应的熔断器，不存在时创建
+func GetBreaker(name string) *Breaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[name]
+	if !ok {
+		b = &Breaker{name: name, state: BreakerClosed}
+		breakers[name] = b
+	}
+	return b
+}
+
+func ParserBreaker(name string) *Breaker {
+	return GetBreaker("parser:" + name)
+}
+
+func WrapperBreaker() *Breaker {
+	return GetBreaker("wrapper")
+}
+
+func AgentBreaker(name string) *Breaker {
+	return GetBreaker("agent:" + name)
+}
+
+func PluginBreaker(url string) *Breaker {
+	return GetBreaker("plugin:" + url)
+}
+
+// 移除不在 urls 中的插件地址的熔断器，插件被删除或更换地址后调用，避免熔断器随历史地址不断增加
+func PrunePluginBreakers(urls []string) {
+	keep := map[string]bool{}
+	for _, url := range urls {
+		keep["plugin:"+url] = true
+	}
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	for name := range breakers {
+		if strings.HasPrefix(name, "plugin:") && !keep[name] {
+			delete(breakers, name)
+		}
+	}
+}
+
+// 所有熔断器的状态，按名称排序
+func Breakers() []BreakerInfo {
+	breakersMu.Lock()
+	list := make([]*Breaker, 0, len(breakers))
+	for _, b := range breakers {
+		list = append(list, b)
+	}
+	breakersMu.Unlock()
+
+	infos := make([]BreakerInfo, 0, len(list))
+	for _, b := range list {
+		infos = append(infos, b.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+func (b *Breaker) Info() BreakerInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	info := BreakerInfo{
+		Name:                b.name,
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		LastError:           b.lastError,
+	}
+	if !b.openedAt.IsZero() && b.state != BreakerClosed {
+		openedAt := b.openedAt
+		info.OpenedAt = &openedAt
+	}
+	if !b.lastFailure.IsZero() {
+		lastFailure := b.lastFailure
+		info.LastFailureAt = &lastFailure
+	}
+	return info
+}
+
+func (b *Breaker) transition(state string) {
+	logs.Warn("Circuit breaker state changed", zap.String("name", b.name), zap.String("from", b.state), zap.String("to", state), zap.Int("failures", b.failures))
+	b.state = state
+	if state == BreakerOpen {
+		b.openedAt = time.Now()
+		metrics.Inc("circuit_breaker_opened", b.name)
+	}
+}
+
+// 判断是否放行请求，放行后必须调用 Record 记录结果
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen {
+		if time.Since(b.openedAt) < BreakerCoolDown {
+			return ErrCircuitOpen
+		}
+		b.transition(BreakerHalfOpen)
+	}
+	if b.state == BreakerHalfOpen {
+		if b.inflight >= BreakerHalfOpenMaxRequests {
+			return ErrCircuitOpen
+		}
+	}
+	b.inflight++
+	return nil
+}
+
+// 记录一次请求结果，err 为 nil 时视为成功
+func (b *Breaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.inflight > 0 {
+		b.inflight--
+	}
+	if err == nil {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			b.transition(BreakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	b.lastFailure = time.Now()
+	b.lastError = err.Error()
+	switch b.state {
+	case BreakerHalfOpen:
+		// 试探请求失败，重新熔断
+		b.transition(BreakerOpen)
+	case BreakerClosed:
+		if b.failures >= BreakerFailureThreshold {
+			b.transition(BreakerOpen)
+		}
+	}
+}
+
+func breakerConfigInit(c BreakerConfig) {
+	if c.FailureThreshold > 0 {
+		BreakerFailureThreshold = c.FailureThreshold
+	}
+	if c.CoolDown > 0 {
+		BreakerCoolDown = time.Duration(c.CoolDown) * time.Second
+	}
+	if c.HalfOpenMaxRequests > 0 {
+		BreakerHalfOpenMaxRequests = c.HalfOpenMaxRequests
+	}
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"carrota-plugin-center/utils"
+	"carrota-plugin-center/utils/logs"
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 下游的重试配置，未填写的字段使用 DefaultRetryPolicy 中的值。
+// jitter 与 retry-on-network-error 的零值也是合法的配置，因此以指针区分是否填写
+type RetryConfig struct {
+	MaxAttempts         int      `config:"max-attempts"`           // 包含首次请求
+	InitialBackoff      int      `config:"initial-backoff"`        // 毫秒
+	MaxBackoff          int      `config:"max-backoff"`            // 毫秒
+	Multiplier          *float64 `config:"multiplier"`             // 每次重试后退避时间的倍数
+	Jitter              *float64 `config:"jitter"`                 // 0~1，退避时间随机浮动的比例
+	RetryOnStatus       []int    `config:"retry-on-status"`        // 需要重试的状态码
+	RetryOnNetworkError *bool    `config:"retry-on-network-error"` // 连接失败、超时等网络错误是否重试
+}
+
+type RetryServiceConfig struct {
+	Parser  RetryConfig `config:"parser"`
+	Wrapper RetryConfig `config:"wrapper"`
+	Agent   RetryConfig `config:"agent"`
+	Plugin  RetryConfig `config:"plugin"`
+}
+
+// 下游请求的重试策略
+type RetryPolicy struct {
+	MaxAttempts         int
+	InitialBackoff      time.Duration
+	MaxBackoff          time.Duration
+	Multiplier          float64
+	Jitter              float64
+	RetryOnStatus       []int
+	RetryOnNetworkError bool
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:         utils.FailedAttempts,
+	InitialBackoff:      200 * time.Millisecond,
+	MaxBackoff:          5 * time.Second,
+	Multiplier:          2,
+	Jitter:              0.2,
+	RetryOnStatus:       []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+	RetryOnNetworkError: true,
+}
+
+var ParserRetryPolicy RetryPolicy
+var WrapperRetryPolicy RetryPolicy
+var AgentRetryPolicy RetryPolicy
+var PluginRetryPolicy RetryPolicy
+
+// 未配置的下游使用默认策略，已配置的下游中未填写或不合法的字段使用默认值
+func newRetryPolicy(c RetryConfig) RetryPolicy {
+	p := DefaultRetryPolicy
+	if c.MaxAttempts > 0 {
+		p.MaxAttempts = c.MaxAttempts
+	}
+	if c.InitialBackoff > 0 {
+		p.InitialBackoff = time.Duration(c.InitialBackoff) * time.Millisecond
+	}
+	if c.MaxBackoff > 0 {
+		p.MaxBackoff = time.Duration(c.MaxBackoff) * time.Millisecond
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		p.MaxBackoff = p.InitialBackoff
+	}
+	if c.Multiplier != nil && *c.Multiplier >= 1 {
+		p.Multiplier = *c.Multiplier
+	}
+	if c.Jitter != nil && *c.Jitter >= 0 && *c.Jitter <= 1 {
+		p.Jitter = *c.Jitter
+	}
+	if c.RetryOnStatus != nil {
+		p.RetryOnStatus = c.RetryOnStatus
+	}
+	if c.RetryOnNetworkError != nil {
+		p.RetryOnNetworkError = *c.RetryOnNetworkError
+	}
+	return p
+}
+
+func (p RetryPolicy) retryableStatus(code int) bool {
+	for _, c := range p.RetryOnStatus {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// 第 attempt 次重试前的退避时间（attempt 从 1 开始）
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	return time.Duration(d)
+}
+
+// 解析 Retry-After 头，支持秒数与 HTTP 日期两种格式
+func retryAfter(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// 下游返回了非 200 的状态码
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", e.StatusCode)
+}
+
+// 按重试策略发送请求，newRequest 每次调用需返回一个新的请求。
+// breaker 不为 nil 时每次请求前检查熔断状态，熔断时直接返回 ErrCircuitOpen。
+// 成功时返回 200 的响应，由调用方关闭；失败时返回最后一次的错误
+func Do(ctx context.Context, name string, breaker *Breaker, policy RetryPolicy, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	attempts := policy.MaxAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if breaker != nil {
+			if err := breaker.Allow(); err != nil {
+				// 重试过程中熔断时返回上一次请求的错误
+				if lastErr != nil {
+					return nil, lastErr
+				}
+				return nil, err
+			}
+		}
+		resp, err := Client.Do(req)
+		if breaker != nil {
+			// 网络错误与 5xx 视为下游故障，其余状态码说明下游仍可用
+			if err != nil {
+				breaker.Record(err)
+			} else if resp.StatusCode >= 500 {
+				breaker.Record(&StatusError{StatusCode: resp.StatusCode})
+			} else {
+				breaker.Record(nil)
+			}
+		}
+		if err == nil && resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+
+		retryable := false
+		wait := policy.Backoff(attempt)
+		if err != nil {
+			retryable = policy.RetryOnNetworkError && ctx.Err() == nil
+			logs.Warn("POST "+name+" failed", zap.String("url", req.URL.String()), zap.Int("attempt", attempt), zap.Error(err))
+		} else {
+			retryable = policy.retryableStatus(resp.StatusCode)
+			// Retry-After 与退避时间取较大值，但不超过 MaxBackoff
+			if d := retryAfter(resp); d > wait {
+				wait = d
+				if wait > policy.MaxBackoff {
+					wait = policy.MaxBackoff
+				}
+			}
+			logs.Warn("POST "+name+" failed", zap.String("url", req.URL.String()), zap.Int("attempt", attempt), zap.Int("statusCode", resp.StatusCode))
+		}
+		if resp != nil {
+			resp.Body.Close()
+			err = &StatusError{StatusCode: resp.StatusCode}
+		}
+		if !retryable || attempt >= attempts {
+			return nil, err
+		}
+		lastErr = err
+
+		// 剩余时间不足以等待时直接放弃
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return nil, context.DeadlineExceeded
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
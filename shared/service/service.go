@@ -1,18 +1,248 @@
 package service
 
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type PluginServiceConfig struct {
+	Timeout             int `config:"timeout"`               // 秒，插件未在注册时指定 timeout 时使用
+	HealthCheckInterval int `config:"health-check-interval"` // 秒，为 0 时不主动探测插件
+	HealthCheckTimeout  int `config:"health-check-timeout"`  // 秒
+}
+
+type QueueServiceConfig struct {
+	Workers     int `config:"workers"`      // 同时处理消息的数量
+	MaxAttempts int `config:"max-attempts"` // 消息处理被重启中断该次数后不再重试
+	Retention   int `config:"retention"`    // 秒，处理完成的消息保留的时间
+}
+
+type OutboxServiceConfig struct {
+	Workers        int `config:"workers"`         // 同时投递的消息数量
+	MaxAttempts    int `config:"max-attempts"`    // 投递失败该次数后标记为失败，包括首次投递
+	InitialBackoff int `config:"initial-backoff"` // 秒，首次投递失败后等待的时间，之后每次翻倍
+	MaxBackoff     int `config:"max-backoff"`     // 秒
+	Timeout        int `config:"timeout"`         // 秒，单次投递（包括 retry.agent 的重试）的期限
+}
+
+// AgentConfig 为配置文件中的一个 Agent，启动时写入 Agent 注册表
+type AgentConfig struct {
+	Name           string   `config:"name"`
+	Endpoint       string   `config:"endpoint"`
+	RecallEndpoint string   `config:"recall-endpoint"` // 为空时使用 endpoint + "/recall"
+	Token          string   `config:"token"`
+	Capabilities   []string `config:"capabilities"`
+}
+
+const (
+	ParserTypeHTTP     = "http"     // 请求 Parser 服务
+	ParserTypeTemplate = "template" // 按插件注册的 format 在本地匹配
+)
+
+// ParserConfig 为 Parser 链中的一个 Parser，消息依次提交，直到某个 Parser 请求成功
+type ParserConfig struct {
+	Type        string `config:"type"`
+	Name        string `config:"name"`
+	Endpoint    string `config:"endpoint"`     // type 为 http 时必填
+	FallThrough bool   `config:"fall-through"` // 没有返回插件时也交给下一个 Parser
+}
+
 type CarrotaServiceConfig struct {
-	AgentEndpoint   string `config:"agent-endpoint"`
-	ParserEndpoint  string `config:"parser-endpoint"`
-	WrapperEndpoint string `config:"wrapper-endpoint"`
+	Agents           []AgentConfig       `config:"agents"`
+	AgentEndpoint    string              `config:"agent-endpoint"`        // 已弃用，注册表中找不到 Agent 时发送到该地址
+	AgentRecall      string              `config:"agent-recall-endpoint"` // 已弃用，为空时使用 agent-endpoint + "/recall"
+	ParserEndpoint   string              `config:"parser-endpoint"`       // 未配置 parsers 时使用该 Parser，并以 template 兜底
+	Parsers          []ParserConfig      `config:"parsers"`
+	WrapperEndpoint  string              `config:"wrapper-endpoint"`
+	WrapperPolicy    string              `config:"wrapper-policy"` // 插件与会话均未设置时的 Wrapper 策略：always、fallback 或 never
+	Plugin           PluginServiceConfig `config:"plugin"`
+	Retry            RetryServiceConfig  `config:"retry"`
+	Breaker          BreakerConfig       `config:"breaker"`
+	Queue            QueueServiceConfig  `config:"queue"`
+	Outbox           OutboxServiceConfig `config:"outbox"`
+	ScheduleTimezone string              `config:"schedule-timezone"`     // 定时消息未指定时区时使用的时区，为空时使用系统时区
+	SyncTimeout      int                 `config:"sync-timeout"`          // 秒，同步处理 /message 的期限
+	ProcessTimeout   int                 `config:"process-timeout"`       // 秒，消息队列处理一条消息的期限
+	ContextTurns     int                 `config:"context-turns"`         // 随 Parser 与插件请求发送的历史消息条数，为 0 时不记录会话历史
+	Idempotency      int                 `config:"idempotency-retention"` // 秒，重复的 /message 与 /message/send 请求在该时间内直接返回首次结果
 }
 
+// 所有下游请求共用的 HTTP Client，超时时间由请求的 context 控制，
+// Timeout 只是兜底，需大于插件允许的最长超时（5 分钟）
+var Client = &http.Client{Timeout: 6 * time.Minute}
+
+var Agents []AgentConfig
 var AgentEndpoint string
-var ParserEndpoint string
+var AgentRecallEndpoint string
+var Parsers []ParserConfig
 var WrapperEndpoint string
+var WrapperPolicy string
+
+var MessageSyncTimeout time.Duration
+var MessageProcessTimeout time.Duration
+var IdempotencyRetention time.Duration
+var ContextTurns int
+
+var QueueWorkers int
+var QueueMaxAttempts int
+var QueueLease time.Duration
+var QueueRetention time.Duration
+
+var OutboxWorkers int
+var OutboxRetryPolicy RetryPolicy
+var OutboxTimeout time.Duration
+var OutboxLease time.Duration
+
+var ScheduleTimezone string
+
+var PluginTimeout time.Duration
+var PluginHealthCheckInterval time.Duration
+var PluginHealthCheckTimeout time.Duration
 
 func CarrotaServiceConfigInit(c CarrotaServiceConfig) error {
+	Agents = c.Agents
 	AgentEndpoint = c.AgentEndpoint
-	ParserEndpoint = c.ParserEndpoint
+	AgentRecallEndpoint = c.AgentRecall
+	if AgentRecallEndpoint == "" && AgentEndpoint != "" {
+		AgentRecallEndpoint = strings.TrimSuffix(AgentEndpoint, "/") + "/recall"
+	}
+	err := parserConfigInit(c)
+	if err != nil {
+		return err
+	}
 	WrapperEndpoint = c.WrapperEndpoint
+	WrapperPolicy = c.WrapperPolicy
+	switch WrapperPolicy {
+	case "":
+		// 与引入 Wrapper 策略前一致，Wrapper 失败时不发送
+		WrapperPolicy = "always"
+	case "always", "fallback", "never":
+	default:
+		return fmt.Errorf("unknown wrapper-policy %q", WrapperPolicy)
+	}
+
+	ParserRetryPolicy = newRetryPolicy(c.Retry.Parser)
+	WrapperRetryPolicy = newRetryPolicy(c.Retry.Wrapper)
+	AgentRetryPolicy = newRetryPolicy(c.Retry.Agent)
+	PluginRetryPolicy = newRetryPolicy(c.Retry.Plugin)
+
+	breakerConfigInit(c.Breaker)
+
+	MessageSyncTimeout = time.Duration(c.SyncTimeout) * time.Second
+	if MessageSyncTimeout <= 0 {
+		MessageSyncTimeout = 15 * time.Second
+	}
+	MessageProcessTimeout = time.Duration(c.ProcessTimeout) * time.Second
+	if MessageProcessTimeout <= 0 {
+		MessageProcessTimeout = 2 * time.Minute
+	}
+
+	ContextTurns = c.ContextTurns
+	if ContextTurns < 0 {
+		ContextTurns = 0
+	}
+
+	IdempotencyRetention = time.Duration(c.Idempotency) * time.Second
+	if IdempotencyRetention <= 0 {
+		IdempotencyRetention = 24 * time.Hour
+	}
+
+	QueueWorkers = c.Queue.Workers
+	if QueueWorkers <= 0 {
+		QueueWorkers = 4
+	}
+	QueueMaxAttempts = c.Queue.MaxAttempts
+	if QueueMaxAttempts <= 0 {
+		QueueMaxAttempts = 3
+	}
+	// 租约留出记录结果的时间，避免正常处理完的消息被其他 worker 重新取出
+	QueueLease = MessageProcessTimeout + 30*time.Second
+	QueueRetention = time.Duration(c.Queue.Retention) * time.Second
+	if QueueRetention <= 0 {
+		QueueRetention = 7 * 24 * time.Hour
+	}
+
+	OutboxWorkers = c.Outbox.Workers
+	if OutboxWorkers <= 0 {
+		OutboxWorkers = 2
+	}
+	OutboxRetryPolicy = RetryPolicy{
+		MaxAttempts:    c.Outbox.MaxAttempts,
+		InitialBackoff: time.Duration(c.Outbox.InitialBackoff) * time.Second,
+		MaxBackoff:     time.Duration(c.Outbox.MaxBackoff) * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+	if OutboxRetryPolicy.MaxAttempts <= 0 {
+		OutboxRetryPolicy.MaxAttempts = 6
+	}
+	if OutboxRetryPolicy.InitialBackoff <= 0 {
+		OutboxRetryPolicy.InitialBackoff = 10 * time.Second
+	}
+	if OutboxRetryPolicy.MaxBackoff <= 0 {
+		OutboxRetryPolicy.MaxBackoff = 10 * time.Minute
+	}
+	if OutboxRetryPolicy.MaxBackoff < OutboxRetryPolicy.InitialBackoff {
+		OutboxRetryPolicy.MaxBackoff = OutboxRetryPolicy.InitialBackoff
+	}
+	OutboxTimeout = time.Duration(c.Outbox.Timeout) * time.Second
+	if OutboxTimeout <= 0 {
+		OutboxTimeout = 30 * time.Second
+	}
+	// 租约留出记录投递结果的时间，避免正常完成的投递被其他 worker 重新取出
+	OutboxLease = OutboxTimeout + 30*time.Second
+
+	ScheduleTimezone = c.ScheduleTimezone
+	if ScheduleTimezone == "" {
+		ScheduleTimezone = "Local"
+	}
+	_, err = time.LoadLocation(ScheduleTimezone)
+	if err != nil {
+		return fmt.Errorf("unknown schedule-timezone %q: %w", ScheduleTimezone, err)
+	}
+
+	PluginTimeout = time.Duration(c.Plugin.Timeout) * time.Second
+	if PluginTimeout <= 0 {
+		PluginTimeout = 10 * time.Second
+	}
+	PluginHealthCheckInterval = time.Duration(c.Plugin.HealthCheckInterval) * time.Second
+	PluginHealthCheckTimeout = time.Duration(c.Plugin.HealthCheckTimeout) * time.Second
+	if PluginHealthCheckTimeout <= 0 {
+		PluginHealthCheckTimeout = 5 * time.Second
+	}
+	return nil
+}
+
+func parserConfigInit(c CarrotaServiceConfig) error {
+	Parsers = c.Parsers
+	if len(Parsers) == 0 {
+		if c.ParserEndpoint != "" {
+			Parsers = append(Parsers, ParserConfig{Type: ParserTypeHTTP, Name: "default", Endpoint: c.ParserEndpoint})
+		}
+		Parsers = append(Parsers, ParserConfig{Type: ParserTypeTemplate, Name: ParserTypeTemplate})
+	}
+
+	names := map[string]bool{}
+	for i := range Parsers {
+		p := &Parsers[i]
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("%s-%d", p.Type, i)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("duplicate parser name %q", p.Name)
+		}
+		names[p.Name] = true
+		switch p.Type {
+		case ParserTypeHTTP:
+			if p.Endpoint == "" {
+				return fmt.Errorf("parser %q requires endpoint", p.Name)
+			}
+		case ParserTypeTemplate:
+		default:
+			return fmt.Errorf("parser %q has unknown type %q", p.Name, p.Type)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,267 @@
+package cron
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("LoadLocation(%q): %v", name, err)
+	}
+	return loc
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"10-5 * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"@every",
+	}
+
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			_, err := Parse(expr)
+			if !errors.Is(err, ErrInvalidExpression) {
+				t.Errorf("Parse(%q) error = %v, want ErrInvalidExpression", expr, err)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	utc := time.UTC
+	shanghai := mustLoadLocation(t, "Asia/Shanghai")
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "every minute excludes from",
+			expr: "* * * * *",
+			from: time.Date(2024, 1, 1, 8, 0, 0, 0, utc),
+			want: time.Date(2024, 1, 1, 8, 1, 0, 0, utc),
+		},
+		{
+			name: "seconds are truncated",
+			expr: "* * * * *",
+			from: time.Date(2024, 1, 1, 8, 0, 59, 999, utc),
+			want: time.Date(2024, 1, 1, 8, 1, 0, 0, utc),
+		},
+		{
+			name: "daily later today",
+			expr: "30 8 * * *",
+			from: time.Date(2024, 1, 1, 7, 0, 0, 0, shanghai),
+			want: time.Date(2024, 1, 1, 8, 30, 0, 0, shanghai),
+		},
+		{
+			name: "daily tomorrow",
+			expr: "30 8 * * *",
+			from: time.Date(2024, 1, 1, 8, 30, 0, 0, shanghai),
+			want: time.Date(2024, 1, 2, 8, 30, 0, 0, shanghai),
+		},
+		{
+			name: "step from start value",
+			expr: "5/15 * * * *",
+			from: time.Date(2024, 1, 1, 8, 6, 0, 0, utc),
+			want: time.Date(2024, 1, 1, 8, 20, 0, 0, utc),
+		},
+		{
+			name: "step from start value wraps to next hour",
+			expr: "5/15 * * * *",
+			from: time.Date(2024, 1, 1, 8, 50, 0, 0, utc),
+			want: time.Date(2024, 1, 1, 9, 5, 0, 0, utc),
+		},
+		{
+			name: "star step",
+			expr: "*/20 * * * *",
+			from: time.Date(2024, 1, 1, 8, 41, 0, 0, utc),
+			want: time.Date(2024, 1, 1, 9, 0, 0, 0, utc),
+		},
+		{
+			name: "range step",
+			expr: "0 9-17/4 * * *",
+			from: time.Date(2024, 1, 1, 13, 0, 0, 0, utc),
+			want: time.Date(2024, 1, 1, 17, 0, 0, 0, utc),
+		},
+		{
+			name: "list",
+			expr: "0 8,12,20 * * *",
+			from: time.Date(2024, 1, 1, 12, 0, 0, 0, utc),
+			want: time.Date(2024, 1, 1, 20, 0, 0, 0, utc),
+		},
+		{
+			name: "7 is sunday",
+			expr: "0 9 * * 7",
+			from: time.Date(2024, 1, 1, 0, 0, 0, 0, utc), // 周一
+			want: time.Date(2024, 1, 7, 9, 0, 0, 0, utc),
+		},
+		{
+			name: "0 is sunday",
+			expr: "0 9 * * 0",
+			from: time.Date(2024, 1, 1, 0, 0, 0, 0, utc),
+			want: time.Date(2024, 1, 7, 9, 0, 0, 0, utc),
+		},
+		{
+			name: "weekday range ending with 7",
+			expr: "0 9 * * 5-7",
+			from: time.Date(2024, 1, 1, 0, 0, 0, 0, utc),
+			want: time.Date(2024, 1, 5, 9, 0, 0, 0, utc),
+		},
+		{
+			name: "weekday names",
+			expr: "0 9 * * MON-FRI",
+			from: time.Date(2024, 1, 5, 10, 0, 0, 0, utc), // 周五
+			want: time.Date(2024, 1, 8, 9, 0, 0, 0, utc),
+		},
+		{
+			name: "month names",
+			expr: "0 0 1 jun *",
+			from: time.Date(2024, 1, 1, 0, 0, 0, 0, utc),
+			want: time.Date(2024, 6, 1, 0, 0, 0, 0, utc),
+		},
+		{
+			name: "day of month or day of week matches day of month",
+			expr: "0 9 15 * 5",
+			from: time.Date(2024, 1, 13, 0, 0, 0, 0, utc), // 周六
+			want: time.Date(2024, 1, 15, 9, 0, 0, 0, utc),
+		},
+		{
+			name: "day of month or day of week matches day of week",
+			expr: "0 9 15 * 5",
+			from: time.Date(2024, 1, 15, 10, 0, 0, 0, utc),
+			want: time.Date(2024, 1, 19, 9, 0, 0, 0, utc),
+		},
+		{
+			name: "star day of week requires day of month",
+			expr: "0 9 15 * *",
+			from: time.Date(2024, 1, 15, 10, 0, 0, 0, utc),
+			want: time.Date(2024, 2, 15, 9, 0, 0, 0, utc),
+		},
+		{
+			name: "star step day of month requires day of week",
+			expr: "0 9 */2 * 1",
+			from: time.Date(2024, 1, 1, 10, 0, 0, 0, utc),
+			want: time.Date(2024, 1, 15, 9, 0, 0, 0, utc),
+		},
+		{
+			name: "31st skips short months",
+			expr: "0 0 31 * *",
+			from: time.Date(2024, 1, 31, 0, 0, 0, 0, utc),
+			want: time.Date(2024, 3, 31, 0, 0, 0, 0, utc),
+		},
+		{
+			name: "leap day",
+			expr: "0 0 29 2 *",
+			from: time.Date(2024, 3, 1, 0, 0, 0, 0, utc),
+			want: time.Date(2028, 2, 29, 0, 0, 0, 0, utc),
+		},
+		{
+			name: "descriptor",
+			expr: "@monthly",
+			from: time.Date(2024, 1, 15, 0, 0, 0, 0, utc),
+			want: time.Date(2024, 2, 1, 0, 0, 0, 0, utc),
+		},
+		{
+			name: "never fires",
+			expr: "0 0 30 2 *",
+			from: time.Date(2024, 1, 1, 0, 0, 0, 0, utc),
+			want: time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.expr, err)
+			}
+			got := s.Next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextDST(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	edt := time.FixedZone("EDT", -4*60*60)
+	est := time.FixedZone("EST", -5*60*60)
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{
+			// 2024-03-10 02:00 EST 直接跳到 03:00 EDT，当天的 02:30 不存在
+			name: "spring forward skips the missing time",
+			expr: "30 2 * * *",
+			from: time.Date(2024, 3, 9, 12, 0, 0, 0, newYork),
+			want: time.Date(2024, 3, 11, 2, 30, 0, 0, edt),
+		},
+		{
+			name: "spring forward hourly",
+			expr: "0 * * * *",
+			from: time.Date(2024, 3, 10, 1, 30, 0, 0, newYork),
+			want: time.Date(2024, 3, 10, 3, 0, 0, 0, edt),
+		},
+		{
+			name: "spring forward from midnight",
+			expr: "0 3 * * *",
+			from: time.Date(2024, 3, 10, 0, 0, 0, 0, newYork),
+			want: time.Date(2024, 3, 10, 3, 0, 0, 0, edt),
+		},
+		{
+			// 2024-11-03 02:00 EDT 回到 01:00 EST，01:30 出现两次
+			name: "fall back first occurrence",
+			expr: "30 1 * * *",
+			from: time.Date(2024, 11, 3, 0, 0, 0, 0, newYork),
+			want: time.Date(2024, 11, 3, 1, 30, 0, 0, edt),
+		},
+		{
+			name: "fall back second occurrence",
+			expr: "30 1 * * *",
+			from: time.Date(2024, 11, 3, 1, 30, 0, 0, edt),
+			want: time.Date(2024, 11, 3, 1, 30, 0, 0, est),
+		},
+		{
+			name: "fall back next day",
+			expr: "30 1 * * *",
+			from: time.Date(2024, 11, 3, 1, 30, 0, 0, est),
+			want: time.Date(2024, 11, 4, 1, 30, 0, 0, est),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.expr, err)
+			}
+			got := s.Next(tt.from.In(newYork))
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
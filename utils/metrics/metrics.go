@@ -0,0 +1,38 @@
+// Package metrics 提供进程内计数器，通过 /metrics 接口查看
+package metrics
+
+import (
+	"sync"
+)
+
+var (
+	mu       sync.Mutex
+	counters = map[string]map[string]int64{}
+)
+
+// Inc 将名为 name 的计数器中 label 对应的值加一，label 通常为插件 ID 或下游服务名
+func Inc(name string, label string) {
+	Add(name, label, 1)
+}
+
+func Add(name string, label string, delta int64) {
+	mu.Lock()
+	defer mu.Unlock()
+	if counters[name] == nil {
+		counters[name] = map[string]int64{}
+	}
+	counters[name][label] += delta
+}
+
+func Snapshot() map[string]map[string]int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	snapshot := make(map[string]map[string]int64, len(counters))
+	for name, labels := range counters {
+		snapshot[name] = make(map[string]int64, len(labels))
+		for label, value := range labels {
+			snapshot[name][label] = value
+		}
+	}
+	return snapshot
+}
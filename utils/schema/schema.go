@@ -0,0 +1,366 @@
+// Package schema 实现插件参数所需的 JSON Schema 子集：
+// type, properties, required, additionalProperties, items, enum, const,
+// minimum, maximum, exclusiveMinimum, exclusiveMaximum, minLength, maxLength,
+// pattern, minItems, maxItems 和 default。
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+	TypeArray   = "array"
+	TypeObject  = "object"
+	TypeNull    = "null"
+)
+
+var ErrNotObject = errors.New("top level schema must be of type object")
+
+var knownTypes = map[string]bool{
+	TypeString:  true,
+	TypeInteger: true,
+	TypeNumber:  true,
+	TypeBoolean: true,
+	TypeArray:   true,
+	TypeObject:  true,
+	TypeNull:    true,
+}
+
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Additional        `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Const                interface{}        `json:"const,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Additional 对应 additionalProperties，可以是布尔值或 Schema
+type Additional struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+func (a *Additional) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &a.Allowed); err == nil {
+		return nil
+	}
+	a.Allowed = true
+	return json.Unmarshal(b, &a.Schema)
+}
+
+func (a Additional) MarshalJSON() ([]byte, error) {
+	if a.Schema != nil {
+		return json.Marshal(a.Schema)
+	}
+	return json.Marshal(a.Allowed)
+}
+
+type Violation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	return v.Path + ": " + v.Message
+}
+
+// Parse 解析并检查 Schema 本身是否合法
+func Parse(raw []byte) (*Schema, error) {
+	s := &Schema{}
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.UseNumber()
+	if err := decoder.Decode(s); err != nil {
+		return nil, err
+	}
+	if err := s.compile("$"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Schema) compile(path string) error {
+	if s.Type != "" && !knownTypes[s.Type] {
+		return fmt.Errorf("%s: unknown type %q", path, s.Type)
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", path, err)
+		}
+		s.pattern = re
+	}
+	for key, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("%s.%s: schema is null", path, key)
+		}
+		if err := property.compile(path + "." + key); err != nil {
+			return err
+		}
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+		if err := s.AdditionalProperties.Schema.compile(path + ".*"); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.compile(path + "[]"); err != nil {
+			return err
+		}
+	}
+	s.Enum = normalizeSlice(s.Enum)
+	s.Const = normalize(s.Const)
+	s.Default = normalize(s.Default)
+	return nil
+}
+
+// normalize 将 json.Number 转为 float64，便于与 encoding/json 默认解析出的值比较
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		f, _ := t.Float64()
+		return f
+	case []interface{}:
+		return normalizeSlice(t)
+	case map[string]interface{}:
+		for k, item := range t {
+			t[k] = normalize(item)
+		}
+	}
+	return v
+}
+
+func normalizeSlice(items []interface{}) []interface{} {
+	for i, item := range items {
+		items[i] = normalize(item)
+	}
+	return items
+}
+
+// Coerce 按 Schema 尽力转换 Parser 返回的参数，如 "True" -> true、"3" -> 3，并填充默认值。
+// 无法转换的值保持原样，交由 Validate 报告
+func (s *Schema) Coerce(v interface{}) interface{} {
+	if s == nil {
+		return v
+	}
+	if v == nil {
+		if s.Default != nil {
+			return s.Default
+		}
+		if s.Type == TypeObject {
+			v = map[string]interface{}{}
+		} else {
+			return nil
+		}
+	}
+
+	switch s.Type {
+	case TypeBoolean:
+		if str, ok := v.(string); ok {
+			if b, err := strconv.ParseBool(strings.TrimSpace(str)); err == nil {
+				return b
+			}
+			switch strings.ToLower(strings.TrimSpace(str)) {
+			case "yes", "y", "是":
+				return true
+			case "no", "n", "否":
+				return false
+			}
+		}
+		if f, ok := v.(float64); ok && (f == 0 || f == 1) {
+			return f == 1
+		}
+	case TypeInteger, TypeNumber:
+		if str, ok := v.(string); ok {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(str), 64); err == nil {
+				return f
+			}
+		}
+		if b, ok := v.(bool); ok {
+			if b {
+				return float64(1)
+			}
+			return float64(0)
+		}
+	case TypeString:
+		switch t := v.(type) {
+		case float64:
+			return strconv.FormatFloat(t, 'f', -1, 64)
+		case bool:
+			return strconv.FormatBool(t)
+		}
+	case TypeArray:
+		items, ok := v.([]interface{})
+		if !ok {
+			// 单个值视为只有一个元素的数组
+			items = []interface{}{v}
+		}
+		coerced := make([]interface{}, len(items))
+		for i, item := range items {
+			coerced[i] = s.Items.Coerce(item)
+		}
+		return coerced
+	case TypeObject:
+		object, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		coerced := make(map[string]interface{}, len(object))
+		for key, value := range object {
+			if property := s.Properties[key]; property != nil {
+				coerced[key] = property.Coerce(value)
+			} else if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+				coerced[key] = s.AdditionalProperties.Schema.Coerce(value)
+			} else {
+				coerced[key] = value
+			}
+		}
+		for key, property := range s.Properties {
+			if _, ok := coerced[key]; !ok && property.Default != nil {
+				coerced[key] = property.Default
+			}
+		}
+		return coerced
+	}
+	return v
+}
+
+// Validate 返回值不符合 Schema 的所有位置，按路径排序
+func (s *Schema) Validate(v interface{}) []Violation {
+	var violations []Violation
+	s.validate("$", v, &violations)
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Path < violations[j].Path })
+	return violations
+}
+
+func typeOf(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return TypeNull
+	case bool:
+		return TypeBoolean
+	case float64:
+		if t == math.Trunc(t) && !math.IsInf(t, 0) {
+			return TypeInteger
+		}
+		return TypeNumber
+	case string:
+		return TypeString
+	case []interface{}:
+		return TypeArray
+	case map[string]interface{}:
+		return TypeObject
+	}
+	return reflect.TypeOf(v).String()
+}
+
+func (s *Schema) validate(path string, v interface{}, violations *[]Violation) {
+	if s == nil {
+		return
+	}
+	add := func(format string, a ...interface{}) {
+		*violations = append(*violations, Violation{Path: path, Message: fmt.Sprintf(format, a...)})
+	}
+
+	actual := typeOf(v)
+	if s.Type != "" && s.Type != actual && !(s.Type == TypeNumber && actual == TypeInteger) {
+		add("expected %s, got %s", s.Type, actual)
+		return
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			add("must be one of %v", s.Enum)
+		}
+	}
+	if s.Const != nil && !reflect.DeepEqual(s.Const, v) {
+		add("must be %v", s.Const)
+	}
+
+	switch t := v.(type) {
+	case float64:
+		if s.Minimum != nil && t < *s.Minimum {
+			add("must be >= %v", *s.Minimum)
+		}
+		if s.Maximum != nil && t > *s.Maximum {
+			add("must be <= %v", *s.Maximum)
+		}
+		if s.ExclusiveMinimum != nil && t <= *s.ExclusiveMinimum {
+			add("must be > %v", *s.ExclusiveMinimum)
+		}
+		if s.ExclusiveMaximum != nil && t >= *s.ExclusiveMaximum {
+			add("must be < %v", *s.ExclusiveMaximum)
+		}
+	case string:
+		length := utf8.RuneCountInString(t)
+		if s.MinLength != nil && length < *s.MinLength {
+			add("length must be >= %d", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			add("length must be <= %d", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(t) {
+			add("must match pattern %q", s.Pattern)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(t) < *s.MinItems {
+			add("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(t) > *s.MaxItems {
+			add("must have at most %d items", *s.MaxItems)
+		}
+		for i, item := range t {
+			s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, violations)
+		}
+	case map[string]interface{}:
+		for _, key := range s.Required {
+			if _, ok := t[key]; !ok {
+				*violations = append(*violations, Violation{Path: path + "." + key, Message: "is required"})
+			}
+		}
+		for key, value := range t {
+			if property := s.Properties[key]; property != nil {
+				property.validate(path+"."+key, value, violations)
+			} else if s.AdditionalProperties != nil {
+				if !s.AdditionalProperties.Allowed {
+					*violations = append(*violations, Violation{Path: path + "." + key, Message: "is not allowed"})
+				} else {
+					s.AdditionalProperties.Schema.validate(path+"."+key, value, violations)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+)
+
+func mustParse(t *testing.T, raw string) *Schema {
+	t.Helper()
+	s, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatalf("Parse(%s): %v", raw, err)
+	}
+	return s
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		ok   bool
+	}{
+		{"object", `{"type": "object", "properties": {"a": {"type": "string"}}}`, true},
+		{"boolean additionalProperties", `{"type": "object", "additionalProperties": false}`, true},
+		{"schema additionalProperties", `{"type": "object", "additionalProperties": {"type": "integer"}}`, true},
+		{"unknown type", `{"type": "object", "properties": {"a": {"type": "str"}}}`, false},
+		{"invalid pattern", `{"type": "string", "pattern": "("}`, false},
+		{"null property", `{"type": "object", "properties": {"a": null}}`, false},
+		{"invalid json", `{"type": `, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.raw))
+			if (err == nil) != tt.ok {
+				t.Errorf("Parse(%s) error = %v, want ok = %v", tt.raw, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCoerce(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  interface{}
+		want   interface{}
+	}{
+		{"bool from string", `{"type": "boolean"}`, "True", true},
+		{"bool from yes", `{"type": "boolean"}`, " yes ", true},
+		{"bool from 否", `{"type": "boolean"}`, "否", false},
+		{"bool from number", `{"type": "boolean"}`, float64(1), true},
+		{"bool keeps other numbers", `{"type": "boolean"}`, float64(2), float64(2)},
+		{"bool keeps unknown string", `{"type": "boolean"}`, "maybe", "maybe"},
+		{"integer from string", `{"type": "integer"}`, " 3 ", float64(3)},
+		{"number from string", `{"type": "number"}`, "2.5", 2.5},
+		{"integer from bool", `{"type": "integer"}`, true, float64(1)},
+		{"integer keeps unknown string", `{"type": "integer"}`, "three", "three"},
+		{"string from number", `{"type": "string"}`, float64(42), "42"},
+		{"string from bool", `{"type": "string"}`, false, "false"},
+		{"array from single value", `{"type": "array", "items": {"type": "integer"}}`, "7", []interface{}{float64(7)}},
+		{"array items", `{"type": "array", "items": {"type": "integer"}}`, []interface{}{"1", float64(2)}, []interface{}{float64(1), float64(2)}},
+		{"null uses default", `{"type": "integer", "default": 5}`, nil, float64(5)},
+		{"null stays null", `{"type": "string"}`, nil, nil},
+		{"null object becomes empty", `{"type": "object"}`, nil, map[string]interface{}{}},
+		{
+			"object properties and defaults",
+			`{"type": "object", "properties": {"n": {"type": "integer"}, "d": {"type": "string", "default": "x"}}}`,
+			map[string]interface{}{"n": "3", "extra": "1"},
+			map[string]interface{}{"n": float64(3), "d": "x", "extra": "1"},
+		},
+		{
+			"object additionalProperties schema",
+			`{"type": "object", "additionalProperties": {"type": "boolean"}}`,
+			map[string]interface{}{"a": "true"},
+			map[string]interface{}{"a": true},
+		},
+		{"object keeps non object", `{"type": "object"}`, "a", "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mustParse(t, tt.schema).Coerce(tt.value)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Coerce(%#v) = %#v, want %#v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  interface{}
+		want   []string
+	}{
+		{"type mismatch", `{"type": "string"}`, float64(1), []string{"$: expected string, got integer"}},
+		{"null is not string", `{"type": "string"}`, nil, []string{"$: expected string, got null"}},
+		{"integer is number", `{"type": "number"}`, float64(1), nil},
+		{"number is not integer", `{"type": "integer"}`, 1.5, []string{"$: expected integer, got number"}},
+		{"enum", `{"enum": ["a", "b"]}`, "c", []string{"$: must be one of [a b]"}},
+		{"enum number", `{"enum": [1, 2]}`, float64(2), nil},
+		{"const", `{"const": "a"}`, "b", []string{"$: must be a"}},
+		{"minimum", `{"type": "number", "minimum": 1}`, float64(0), []string{"$: must be >= 1"}},
+		{"exclusive maximum", `{"type": "number", "exclusiveMaximum": 1}`, float64(1), []string{"$: must be < 1"}},
+		{"max length counts runes", `{"type": "string", "maxLength": 2}`, "语文", nil},
+		{"min length", `{"type": "string", "minLength": 3}`, "语文", []string{"$: length must be >= 3"}},
+		{"pattern", `{"type": "string", "pattern": "^[a-z]+$"}`, "A", []string{`$: must match pattern "^[a-z]+$"`}},
+		{"min items", `{"type": "array", "minItems": 1}`, []interface{}{}, []string{"$: must have at least 1 items"}},
+		{"items", `{"type": "array", "items": {"type": "string"}}`, []interface{}{"a", true}, []string{"$[1]: expected string, got boolean"}},
+		{
+			"required and properties sorted by path",
+			`{"type": "object", "required": ["a"], "properties": {"b": {"type": "integer"}, "c": {"type": "string"}}}`,
+			map[string]interface{}{"b": "x", "c": float64(1)},
+			[]string{"$.a: is required", "$.b: expected integer, got string", "$.c: expected string, got integer"},
+		},
+		{
+			"additionalProperties false",
+			`{"type": "object", "properties": {"a": {}}, "additionalProperties": false}`,
+			map[string]interface{}{"a": "x", "b": "y"},
+			[]string{"$.b: is not allowed"},
+		},
+		{
+			"additionalProperties schema",
+			`{"type": "object", "additionalProperties": {"type": "integer"}}`,
+			map[string]interface{}{"a": "x"},
+			[]string{"$.a: expected integer, got string"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, v := range mustParse(t, tt.schema).Validate(tt.value) {
+				got = append(got, v.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate(%#v) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
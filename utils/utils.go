@@ -20,3 +20,6 @@ func RandSeq(leng int) string {
 }
 
 const FailedAttempts = 3
+
+// 插件连续投递失败该次数后被停用，直到重新注册
+const PluginMaxConsecutiveFailures = 3